@@ -1,6 +1,8 @@
 package commonLib
 
 import (
+	"os"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
@@ -11,6 +13,10 @@ import (
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
+// EndpointEnvVar names the environment variable used to override the AWS endpoint of every client,
+// e.g. to point the CLI and lambda at a local fakeaws.Server for end-to-end tests
+const EndpointEnvVar = "SCHISM_AWS_ENDPOINT"
+
 // SSMClient returns a new AWS SSM Client in a given region
 func SSMClient(region string) ssmiface.SSMAPI {
 	return ssm.New(AwsSession(region))
@@ -30,6 +36,9 @@ func S3Client(region string) s3iface.S3API {
 //
 // This also hard-enables SharedConfig access for Schism AWS API access.
 //
+// If EndpointEnvVar is set, all requests are sent to that endpoint instead of AWS
+// and S3 requests use path-style addressing.
+//
 // TODO: Make this configurable somehow. (Lambda vs CLI Tool?)
 func AwsSession(region string) *session.Session {
 	sessionOpts := session.Options{
@@ -38,6 +47,10 @@ func AwsSession(region string) *session.Session {
 		},
 		SharedConfigState: session.SharedConfigEnable,
 	}
+	if endpoint := os.Getenv(EndpointEnvVar); endpoint != "" {
+		sessionOpts.Config.Endpoint = aws.String(endpoint)
+		sessionOpts.Config.S3ForcePathStyle = aws.Bool(true)
+	}
 	awsSession := session.Must(session.NewSessionWithOptions(sessionOpts))
 	return awsSession
 }
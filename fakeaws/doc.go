@@ -0,0 +1,10 @@
+// Package fakeaws provides in-memory stand-ins for the AWS APIs used by Schism.
+//
+// The fakes satisfy the same s3iface, lambdaiface and ssmiface interfaces
+// returned by commonLib.S3Client, commonLib.LambdaClient and commonLib.SSMClient,
+// so downstream projects can exercise the real protocol code in tests
+// without touching AWS.
+//
+// Only the calls Schism actually makes are implemented, calling anything else
+// will panic on the embedded nil interface.
+package fakeaws
@@ -0,0 +1,110 @@
+package fakeaws
+
+import (
+	"fmt"
+	"sync"
+
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// LambdaHandler is the Go function backing a fake Lambda function.
+// It receives the raw invocation payload and returns the raw response payload.
+//
+// Returning an error is reported the same way Lambda reports an unhandled error,
+// FunctionError is set to "Unhandled" and the payload holds the errorMessage and errorType
+type LambdaHandler func(payload []byte) ([]byte, error)
+
+// JSONHandler adapts a typed function into a LambdaHandler,
+// un-marshaling the payload into Req and marshaling the returned Resp
+func JSONHandler[Req any, Resp any](fn func(Req) (Resp, error)) LambdaHandler {
+	return func(payload []byte) ([]byte, error) {
+		var req Req
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		resp, err := fn(req)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(resp)
+	}
+}
+
+// LambdaError is the payload returned by Lambda when a function returns an error
+type LambdaError struct {
+	ErrorMessage string `json:"errorMessage"`
+	ErrorType    string `json:"errorType"`
+}
+
+// Lambda is an in-memory implementation of the parts of lambdaiface.LambdaAPI used by Schism
+//
+// Invocations are routed synchronously to the LambdaHandler registered under the function name
+type Lambda struct {
+	lambdaiface.LambdaAPI
+
+	mu          sync.RWMutex
+	functions   map[string]LambdaHandler
+	invocations map[string]int
+}
+
+// NewLambda returns a fake Lambda service with no functions registered
+func NewLambda() *Lambda {
+	return &Lambda{
+		functions:   map[string]LambdaHandler{},
+		invocations: map[string]int{},
+	}
+}
+
+// Register routes invocations of the named function to handler, replacing any previous handler
+func (l *Lambda) Register(functionName string, handler LambdaHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.functions[functionName] = handler
+}
+
+// Invocations returns how many times the named function has been invoked
+func (l *Lambda) Invocations(functionName string) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.invocations[functionName]
+}
+
+// Invoke calls the registered handler with the input payload
+//
+// Unknown functions return a lambda.ErrCodeResourceNotFoundException error,
+// "Event" invocations discard the handler's response like the real service does
+func (l *Lambda) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	name := aws.StringValue(input.FunctionName)
+	l.mu.Lock()
+	handler, ok := l.functions[name]
+	if ok {
+		l.invocations[name]++
+	}
+	l.mu.Unlock()
+	if !ok {
+		return nil, awserr.New(lambda.ErrCodeResourceNotFoundException, fmt.Sprintf("Function not found: %s", name), nil)
+	}
+
+	payload, err := handler(input.Payload)
+	if aws.StringValue(input.InvocationType) == lambda.InvocationTypeEvent {
+		return &lambda.InvokeOutput{StatusCode: aws.Int64(202)}, nil
+	}
+	output := &lambda.InvokeOutput{
+		StatusCode:      aws.Int64(200),
+		ExecutedVersion: aws.String("$LATEST"),
+	}
+	if err != nil {
+		output.FunctionError = aws.String("Unhandled")
+		payload, _ = json.Marshal(&LambdaError{
+			ErrorMessage: err.Error(),
+			ErrorType:    fmt.Sprintf("%T", err),
+		})
+	}
+	output.Payload = payload
+	return output, nil
+}
@@ -0,0 +1,90 @@
+package fakeaws_test
+
+import (
+	"errors"
+	"testing"
+
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+)
+
+type echoRequest struct {
+	Message string `json:"message"`
+}
+
+func TestLambda_Invoke(t *testing.T) {
+	lambdaSvc := fakeaws.NewLambda()
+	lambdaSvc.Register("echo", fakeaws.JSONHandler(func(req echoRequest) (echoRequest, error) {
+		if req.Message == "" {
+			return req, errors.New("empty message")
+		}
+		return req, nil
+	}))
+	tests := []struct {
+		name              string
+		functionName      string
+		payload           string
+		want              string
+		wantFunctionError bool
+		wantErr           bool
+	}{
+		{
+			name:         "routes the payload to the handler",
+			functionName: "echo",
+			payload:      `{"message":"hello"}`,
+			want:         `{"message":"hello"}`,
+		},
+		{
+			name:              "handler errors become function errors",
+			functionName:      "echo",
+			payload:           `{}`,
+			want:              `{"errorMessage":"empty message","errorType":"*errors.errorString"}`,
+			wantFunctionError: true,
+		},
+		{
+			name:         "unknown functions return an aws error",
+			functionName: "nope",
+			payload:      `{}`,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lambdaSvc.Invoke(&lambda.InvokeInput{
+				FunctionName: aws.String(tt.functionName),
+				Payload:      []byte(tt.payload),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Invoke() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != lambda.ErrCodeResourceNotFoundException {
+					t.Errorf("Invoke() error = %v, want %s", err, lambda.ErrCodeResourceNotFoundException)
+				}
+				return
+			}
+			if (got.FunctionError != nil) != tt.wantFunctionError {
+				t.Errorf("Invoke() FunctionError = %v, want %v", got.FunctionError, tt.wantFunctionError)
+			}
+			var gotPayload, wantPayload interface{}
+			_ = json.Unmarshal(got.Payload, &gotPayload)
+			_ = json.Unmarshal([]byte(tt.want), &wantPayload)
+			if string(mustMarshal(gotPayload)) != string(mustMarshal(wantPayload)) {
+				t.Errorf("Invoke() payload = %s, want %s", got.Payload, tt.want)
+			}
+		})
+	}
+	if n := lambdaSvc.Invocations("echo"); n != 2 {
+		t.Errorf("Invocations() = %d, want 2", n)
+	}
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
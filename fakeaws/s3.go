@@ -0,0 +1,400 @@
+package fakeaws
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"crypto/md5"
+	"encoding/base64"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// DefaultMaxKeys is the page size used by ListObjectsV2 when the input doesn't set MaxKeys,
+// it matches the default of the real S3 API
+const DefaultMaxKeys int64 = 1000
+
+// S3Object is a single object stored in the fake S3 service
+type S3Object struct {
+	Body         []byte
+	ContentType  string
+	Metadata     map[string]string
+	LastModified time.Time
+}
+
+// ETag returns the quoted md5 sum of the object body, the same way S3 does for non-multipart uploads
+func (o *S3Object) ETag() string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(o.Body)))
+}
+
+// S3 is a fake implementation of the parts of s3iface.S3API used by Schism,
+// objects are kept in memory (NewS3) or in a local directory (NewDirS3)
+//
+// Buckets must be created before use, either with NewS3 or AddBucket,
+// requests against unknown buckets fail with s3.ErrCodeNoSuchBucket
+type S3 struct {
+	s3iface.S3API
+
+	// Now is used to stamp LastModified on new objects, defaults to time.Now
+	Now func() time.Time
+	// Endpoint presigned URLs point at (see GetObjectRequest), Server.Start sets it when empty
+	Endpoint string
+
+	mu    sync.RWMutex
+	store s3Store
+}
+
+// NewS3 returns an empty fake S3 service with the given buckets already created
+func NewS3(buckets ...string) *S3 {
+	s := &S3{Now: time.Now, store: memoryStore{}}
+	for _, bucket := range buckets {
+		_ = s.AddBucket(bucket)
+	}
+	return s
+}
+
+// NewDirS3 returns a fake S3 service that keeps objects as files below root,
+// every existing sub-directory of root is a bucket and keys map to paths inside it.
+//
+// This is useful for seeding a fake from checked in testdata or inspecting what a test wrote
+func NewDirS3(root string) (*S3, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &S3{Now: time.Now, store: dirStore(root)}, nil
+}
+
+// AddBucket adds an empty bucket to the fake, this is a no-op for existing buckets
+func (s *S3) AddBucket(bucket string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.addBucket(bucket)
+}
+
+// Put stores body under s3://{bucket}/{key}, creating the bucket if needed
+//
+// This is a shortcut for seeding test data without building a PutObjectInput
+func (s *S3) Put(bucket string, key string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.store.addBucket(bucket); err != nil {
+		return err
+	}
+	return s.store.put(bucket, key, &S3Object{Body: body, LastModified: s.Now()})
+}
+
+// Object returns a copy of the object stored at s3://{bucket}/{key}, or nil if there is none
+func (s *S3) Object(bucket string, key string) *S3Object {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, err := s.store.get(bucket, key)
+	if err != nil || obj == nil {
+		return nil
+	}
+	cp := *obj
+	cp.Body = append([]byte(nil), obj.Body...)
+	return &cp
+}
+
+// Keys returns the sorted keys in bucket that start with prefix
+func (s *S3) Keys(bucket string, prefix string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys, _ := s.store.keys(bucket, prefix)
+	return keys
+}
+
+// PutObject stores the request body in memory, replacing any existing object with the same key
+func (s *S3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if input.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(input.Body); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.bucket(input.Bucket); err != nil {
+		return nil, err
+	}
+	obj := &S3Object{
+		Body:         body,
+		ContentType:  aws.StringValue(input.ContentType),
+		Metadata:     aws.StringValueMap(input.Metadata),
+		LastModified: s.Now(),
+	}
+	if err := s.store.put(aws.StringValue(input.Bucket), aws.StringValue(input.Key), obj); err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectOutput{ETag: aws.String(obj.ETag())}, nil
+}
+
+// GetObject returns the stored object or an s3.ErrCodeNoSuchKey error
+func (s *S3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, err := s.object(input.Bucket, input.Key)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(append([]byte(nil), obj.Body...))),
+		ContentLength: aws.Int64(int64(len(obj.Body))),
+		ETag:          aws.String(obj.ETag()),
+		LastModified:  aws.Time(obj.LastModified),
+		Metadata:      aws.StringMap(obj.Metadata),
+	}
+	if obj.ContentType != "" {
+		output.ContentType = aws.String(obj.ContentType)
+	}
+	return output, nil
+}
+
+// HeadObject returns the metadata of the stored object or an s3.ErrCodeNoSuchKey error
+func (s *S3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, err := s.object(input.Bucket, input.Key)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.Body))),
+		ETag:          aws.String(obj.ETag()),
+		LastModified:  aws.Time(obj.LastModified),
+		Metadata:      aws.StringMap(obj.Metadata),
+	}
+	if obj.ContentType != "" {
+		output.ContentType = aws.String(obj.ContentType)
+	}
+	return output, nil
+}
+
+// DeleteObject removes the object, deleting a missing key is not an error (same as S3)
+func (s *S3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.bucket(input.Bucket); err != nil {
+		return nil, err
+	}
+	if err := s.store.delete(aws.StringValue(input.Bucket), aws.StringValue(input.Key)); err != nil {
+		return nil, err
+	}
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// ListObjectsV2 lists the objects in a bucket in lexical order
+//
+// Prefix, Delimiter, StartAfter, MaxKeys and ContinuationToken are supported,
+// continuation tokens are opaque and only valid for this fake
+func (s *S3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.bucket(input.Bucket); err != nil {
+		return nil, err
+	}
+	keys, err := s.store.keys(aws.StringValue(input.Bucket), aws.StringValue(input.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	maxKeys := aws.Int64Value(input.MaxKeys)
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	after := aws.StringValue(input.StartAfter)
+	if token := aws.StringValue(input.ContinuationToken); token != "" {
+		raw, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, awserr.New("InvalidArgument", "the continuation token provided is incorrect", err)
+		}
+		after = string(raw)
+	}
+	prefix := aws.StringValue(input.Prefix)
+	delimiter := aws.StringValue(input.Delimiter)
+
+	output := &s3.ListObjectsV2Output{
+		Name:              input.Bucket,
+		Prefix:            input.Prefix,
+		Delimiter:         input.Delimiter,
+		StartAfter:        input.StartAfter,
+		ContinuationToken: input.ContinuationToken,
+		MaxKeys:           aws.Int64(maxKeys),
+		IsTruncated:       aws.Bool(false),
+	}
+	var (
+		count    int64
+		last     string
+		prefixes = map[string]bool{}
+	)
+	for _, key := range keys {
+		if key <= after {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if prefixes[common] || common <= after {
+					continue
+				}
+				if count == maxKeys {
+					output.IsTruncated = aws.Bool(true)
+					break
+				}
+				prefixes[common] = true
+				output.CommonPrefixes = append(output.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(common)})
+				count++
+				last = common
+				continue
+			}
+		}
+		if count == maxKeys {
+			output.IsTruncated = aws.Bool(true)
+			break
+		}
+		obj, err := s.store.get(aws.StringValue(input.Bucket), key)
+		if err != nil {
+			return nil, err
+		}
+		output.Contents = append(output.Contents, &s3.Object{
+			Key:          aws.String(key),
+			ETag:         aws.String(obj.ETag()),
+			LastModified: aws.Time(obj.LastModified),
+			Size:         aws.Int64(int64(len(obj.Body))),
+			StorageClass: aws.String(s3.ObjectStorageClassStandard),
+		})
+		count++
+		last = key
+	}
+	if aws.BoolValue(output.IsTruncated) {
+		output.NextContinuationToken = aws.String(base64.StdEncoding.EncodeToString([]byte(last)))
+	}
+	output.KeyCount = aws.Int64(count)
+	return output, nil
+}
+
+// ListObjectsV2Pages iterates over every page of ListObjectsV2 until fn returns false
+func (s *S3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	in := *input
+	for {
+		page, err := s.ListObjectsV2(&in)
+		if err != nil {
+			return err
+		}
+		lastPage := !aws.BoolValue(page.IsTruncated)
+		if !fn(page, lastPage) || lastPage {
+			return nil
+		}
+		in.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+// bucket must be called with s.mu held
+func (s *S3) bucket(name *string) error {
+	if !s.store.hasBucket(aws.StringValue(name)) {
+		return awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	return nil
+}
+
+// object must be called with s.mu held
+func (s *S3) object(bucketName *string, key *string) (*S3Object, error) {
+	if err := s.bucket(bucketName); err != nil {
+		return nil, err
+	}
+	obj, err := s.store.get(aws.StringValue(bucketName), aws.StringValue(key))
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return obj, nil
+}
+
+// GetObjectWithContext is GetObject, failing early if ctx is already done
+func (s *S3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.GetObject(input)
+}
+
+// HeadObjectWithContext is HeadObject, failing early if ctx is already done
+func (s *S3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.HeadObject(input)
+}
+
+// PutObjectWithContext is PutObject, failing early if ctx is already done
+func (s *S3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.PutObject(input)
+}
+
+// ListObjectsV2WithContext is ListObjectsV2, failing early if ctx is already done
+func (s *S3) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, _ ...request.Option) (*s3.ListObjectsV2Output, error) {
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+	return s.ListObjectsV2(input)
+}
+
+// ListObjectsV2PagesWithContext is ListObjectsV2Pages, checking ctx before every page
+func (s *S3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	var ctxErr error
+	err := s.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		if ctxErr = contextErr(ctx); ctxErr != nil {
+			return false
+		}
+		return fn(page, lastPage)
+	})
+	if ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// contextErr converts a done context into the error the SDK returns for canceled requests
+func contextErr(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+// presignEndpoint is used for presigned URLs when S3.Endpoint isn't set, it never resolves
+const presignEndpoint = "http://fakeaws.invalid"
+
+// GetObjectRequest returns a request built by a real aws-sdk-go S3 client pointed at Endpoint
+// with static credentials, it is only meant to be presigned.
+//
+// Sending the request goes to Endpoint, not this fake, unless a Server serving this fake is listening there
+func (s *S3) GetObjectRequest(input *s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = presignEndpoint
+	}
+	client := s3.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("fakeaws", "fakeaws", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})))
+	return client.GetObjectRequest(input)
+}
@@ -0,0 +1,197 @@
+package fakeaws
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+)
+
+// s3Store is the storage behind the fake S3 service.
+//
+// Implementations don't need to be safe for concurrent use, S3 serializes access to them
+type s3Store interface {
+	hasBucket(bucket string) bool
+	addBucket(bucket string) error
+	get(bucket string, key string) (*S3Object, error)
+	put(bucket string, key string, obj *S3Object) error
+	delete(bucket string, key string) error
+	keys(bucket string, prefix string) ([]string, error)
+}
+
+// memoryStore keeps every object in a map, it is the default store for NewS3
+type memoryStore map[string]map[string]*S3Object
+
+func (m memoryStore) hasBucket(bucket string) bool {
+	_, ok := m[bucket]
+	return ok
+}
+
+func (m memoryStore) addBucket(bucket string) error {
+	if !m.hasBucket(bucket) {
+		m[bucket] = map[string]*S3Object{}
+	}
+	return nil
+}
+
+func (m memoryStore) get(bucket string, key string) (*S3Object, error) {
+	return m[bucket][key], nil
+}
+
+func (m memoryStore) put(bucket string, key string, obj *S3Object) error {
+	m[bucket][key] = obj
+	return nil
+}
+
+func (m memoryStore) delete(bucket string, key string) error {
+	delete(m[bucket], key)
+	return nil
+}
+
+func (m memoryStore) keys(bucket string, prefix string) ([]string, error) {
+	var keys []string
+	for key := range m[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// dirMetaDir holds the ContentType and Metadata of objects in a dirStore,
+// mirroring the bucket/key layout of the objects themselves
+const dirMetaDir = ".fakeaws-meta"
+
+// dirStore keeps objects as plain files, every sub-directory of root is a bucket
+// and object keys are paths below the bucket directory
+type dirStore string
+
+type dirMeta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func (d dirStore) path(parts ...string) string {
+	return filepath.Join(append([]string{string(d)}, parts...)...)
+}
+
+// objectPath returns the file path for an object below base,
+// keys that would escape the bucket directory are rejected
+func (d dirStore) objectPath(base string, bucket string, key string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %q cannot be stored in a directory", key)
+	}
+	return filepath.Join(base, bucket, rel), nil
+}
+
+func (d dirStore) hasBucket(bucket string) bool {
+	if bucket == "" || bucket == dirMetaDir || strings.ContainsAny(bucket, `/\`) {
+		return false
+	}
+	info, err := os.Stat(d.path(bucket))
+	return err == nil && info.IsDir()
+}
+
+func (d dirStore) addBucket(bucket string) error {
+	return os.MkdirAll(d.path(bucket), 0o755)
+}
+
+func (d dirStore) get(bucket string, key string) (*S3Object, error) {
+	objPath, err := d.objectPath(string(d), bucket, key)
+	if err != nil {
+		return nil, nil
+	}
+	info, err := os.Stat(objPath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(objPath)
+	if err != nil {
+		return nil, err
+	}
+	obj := &S3Object{Body: body, LastModified: info.ModTime()}
+	metaPath, _ := d.objectPath(d.path(dirMetaDir), bucket, key)
+	if raw, err := os.ReadFile(metaPath); err == nil {
+		var meta dirMeta
+		if err = json.Unmarshal(raw, &meta); err != nil {
+			return nil, err
+		}
+		obj.ContentType = meta.ContentType
+		obj.Metadata = meta.Metadata
+	}
+	return obj, nil
+}
+
+func (d dirStore) put(bucket string, key string, obj *S3Object) error {
+	objPath, err := d.objectPath(string(d), bucket, key)
+	if err != nil {
+		return err
+	}
+	metaPath, _ := d.objectPath(d.path(dirMetaDir), bucket, key)
+	if err = os.MkdirAll(filepath.Dir(objPath), 0o755); err != nil {
+		return err
+	}
+	if err = os.WriteFile(objPath, obj.Body, 0o644); err != nil {
+		return err
+	}
+	if err = os.Chtimes(objPath, obj.LastModified, obj.LastModified); err != nil {
+		return err
+	}
+	if obj.ContentType == "" && len(obj.Metadata) == 0 {
+		if err = os.Remove(metaPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	raw, err := json.Marshal(&dirMeta{ContentType: obj.ContentType, Metadata: obj.Metadata})
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(metaPath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath, raw, 0o644)
+}
+
+func (d dirStore) delete(bucket string, key string) error {
+	objPath, err := d.objectPath(string(d), bucket, key)
+	if err != nil {
+		return nil
+	}
+	metaPath, _ := d.objectPath(d.path(dirMetaDir), bucket, key)
+	for _, p := range []string{objPath, metaPath} {
+		if err = os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d dirStore) keys(bucket string, prefix string) ([]string, error) {
+	root := d.path(bucket)
+	var keys []string
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
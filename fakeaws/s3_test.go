@@ -0,0 +1,208 @@
+package fakeaws_test
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+)
+
+const testBucket = "schism-test"
+
+func TestS3_PutGetDelete(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testBucket)
+	_, err := s3Svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(testBucket),
+		Key:         aws.String("CA-Pubkeys/user.json"),
+		Body:        strings.NewReader(`{"certificate_type":"user"}`),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	got, err := s3Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("CA-Pubkeys/user.json"),
+	})
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	body, _ := ioutil.ReadAll(got.Body)
+	if string(body) != `{"certificate_type":"user"}` {
+		t.Errorf("GetObject() body = %s", body)
+	}
+	if aws.StringValue(got.ContentType) != "application/json" {
+		t.Errorf("GetObject() ContentType = %v, want application/json", aws.StringValue(got.ContentType))
+	}
+
+	if _, err = s3Svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("CA-Pubkeys/user.json"),
+	}); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	_, err = s3Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("CA-Pubkeys/user.json"),
+	})
+	if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != s3.ErrCodeNoSuchKey {
+		t.Errorf("GetObject() after delete error = %v, want %s", err, s3.ErrCodeNoSuchKey)
+	}
+}
+
+func TestS3_NoSuchBucket(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testBucket)
+	_, err := s3Svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("this-bucket-is-a-lie")})
+	if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != s3.ErrCodeNoSuchBucket {
+		t.Errorf("ListObjectsV2() error = %v, want %s", err, s3.ErrCodeNoSuchBucket)
+	}
+}
+
+func TestS3_ListObjectsV2(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testBucket)
+	for _, key := range []string{
+		"CA-Pubkeys/host.json",
+		"CA-Pubkeys/user.json",
+		"Signed-Certs/host:55e8.json",
+		"Signed-Certs/user:4d5b.json",
+		"Signed-Certs/user:4e15.json",
+	} {
+		s3Svc.Put(testBucket, key, []byte("{}"))
+	}
+	tests := []struct {
+		name           string
+		input          *s3.ListObjectsV2Input
+		wantKeys       []string
+		wantPrefixes   []string
+		wantTruncation bool
+	}{
+		{
+			name:     "prefix filters keys",
+			input:    &s3.ListObjectsV2Input{Prefix: aws.String("Signed-Certs/user:4")},
+			wantKeys: []string{"Signed-Certs/user:4d5b.json", "Signed-Certs/user:4e15.json"},
+		},
+		{
+			name:     "no matches",
+			input:    &s3.ListObjectsV2Input{Prefix: aws.String("Signed-Certs/user:0")},
+			wantKeys: nil,
+		},
+		{
+			name:           "max keys truncates",
+			input:          &s3.ListObjectsV2Input{MaxKeys: aws.Int64(2)},
+			wantKeys:       []string{"CA-Pubkeys/host.json", "CA-Pubkeys/user.json"},
+			wantTruncation: true,
+		},
+		{
+			name:     "start after skips keys",
+			input:    &s3.ListObjectsV2Input{StartAfter: aws.String("Signed-Certs/user:4d5b.json")},
+			wantKeys: []string{"Signed-Certs/user:4e15.json"},
+		},
+		{
+			name:         "delimiter rolls up common prefixes",
+			input:        &s3.ListObjectsV2Input{Delimiter: aws.String("/")},
+			wantPrefixes: []string{"CA-Pubkeys/", "Signed-Certs/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.input.Bucket = aws.String(testBucket)
+			got, err := s3Svc.ListObjectsV2(tt.input)
+			if err != nil {
+				t.Fatalf("ListObjectsV2() error = %v", err)
+			}
+			var gotKeys, gotPrefixes []string
+			for _, obj := range got.Contents {
+				gotKeys = append(gotKeys, *obj.Key)
+			}
+			for _, cp := range got.CommonPrefixes {
+				gotPrefixes = append(gotPrefixes, *cp.Prefix)
+			}
+			if !reflect.DeepEqual(gotKeys, tt.wantKeys) {
+				t.Errorf("ListObjectsV2() keys = %v, want %v", gotKeys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(gotPrefixes, tt.wantPrefixes) {
+				t.Errorf("ListObjectsV2() prefixes = %v, want %v", gotPrefixes, tt.wantPrefixes)
+			}
+			if *got.IsTruncated != tt.wantTruncation {
+				t.Errorf("ListObjectsV2() IsTruncated = %v, want %v", *got.IsTruncated, tt.wantTruncation)
+			}
+			if *got.KeyCount != int64(len(gotKeys)+len(gotPrefixes)) {
+				t.Errorf("ListObjectsV2() KeyCount = %v", *got.KeyCount)
+			}
+		})
+	}
+}
+
+func TestS3_ListObjectsV2Pages(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testBucket)
+	want := []string{"a", "b", "c", "d", "e"}
+	for _, key := range want {
+		s3Svc.Put(testBucket, key, nil)
+	}
+	var (
+		got   []string
+		pages int
+	)
+	err := s3Svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:  aws.String(testBucket),
+		MaxKeys: aws.Int64(2),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		pages++
+		for _, obj := range page.Contents {
+			got = append(got, *obj.Key)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("ListObjectsV2Pages() error = %v", err)
+	}
+	if pages != 3 {
+		t.Errorf("ListObjectsV2Pages() pages = %d, want 3", pages)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListObjectsV2Pages() got = %v, want %v", got, want)
+	}
+}
+
+func TestS3_WithContext(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testBucket)
+	_ = s3Svc.Put(testBucket, "a.json", []byte("{}"))
+	input := &s3.GetObjectInput{Bucket: aws.String(testBucket), Key: aws.String("a.json")}
+	if _, err := s3Svc.GetObjectWithContext(context.Background(), input); err != nil {
+		t.Fatalf("GetObjectWithContext() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s3Svc.GetObjectWithContext(ctx, input)
+	if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != request.CanceledErrorCode {
+		t.Errorf("GetObjectWithContext() error = %v, want %v", err, request.CanceledErrorCode)
+	}
+	err = s3Svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(testBucket)}, func(*s3.ListObjectsV2Output, bool) bool {
+		t.Errorf("ListObjectsV2PagesWithContext() called fn with a canceled context")
+		return true
+	})
+	if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != request.CanceledErrorCode {
+		t.Errorf("ListObjectsV2PagesWithContext() error = %v, want %v", err, request.CanceledErrorCode)
+	}
+}
+
+func TestS3_GetObjectRequest(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testBucket)
+	req, _ := s3Svc.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(testBucket), Key: aws.String("a.json")})
+	url, err := req.Presign(time.Minute)
+	if err != nil {
+		t.Fatalf("Presign() error = %v", err)
+	}
+	if want := "http://fakeaws.invalid/" + testBucket + "/a.json?"; !strings.HasPrefix(url, want) {
+		t.Errorf("Presign() = %v, want prefix %v", url, want)
+	}
+}
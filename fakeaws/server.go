@@ -0,0 +1,497 @@
+package fakeaws
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"encoding/json"
+	"encoding/xml"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// lambdaInvokePrefix is the REST path Lambda Invoke requests are sent to:
+//
+//	/2015-03-31/functions/{FunctionName}/invocations
+const lambdaInvokePrefix = "/2015-03-31/functions/"
+
+// ssmTargetPrefix prefixes the X-Amz-Target header of every SSM JSON-RPC request
+const ssmTargetPrefix = "AmazonSSM."
+
+// s3TimeFormat is the timestamp format used in S3 XML bodies
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Server speaks enough of the S3, Lambda and SSM wire protocols for aws-sdk-go clients
+// to talk to it, requests are served by the configured service implementations
+// (usually the fakes from this package).
+//
+// Point clients at it by setting the commonLib.EndpointEnvVar environment variable
+// to the endpoint returned by Start, S3 requests must use path-style addressing.
+//
+// Supported calls:
+//
+//	S3: GetObject, HeadObject, PutObject, DeleteObject, ListObjectsV2
+//	Lambda: Invoke
+//	SSM: GetParameter, GetParametersByPath, PutParameter, DeleteParameter
+//
+// Request signatures are not verified.
+type Server struct {
+	S3     s3iface.S3API
+	Lambda lambdaiface.LambdaAPI
+	SSM    ssmiface.SSMAPI
+
+	httpServer *http.Server
+	listener   net.Listener
+}
+
+// Start listens on addr (use "127.0.0.1:0" for a random port) and serves requests in the background
+// until Close is called. A fake S3 without an Endpoint has it set to the server, so presigned URLs work.
+//
+// Returns the endpoint URL clients should be configured with
+func (srv *Server) Start(addr string) (string, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	srv.listener = listener
+	srv.httpServer = &http.Server{Handler: srv}
+	go func() { _ = srv.httpServer.Serve(listener) }()
+	if fake, ok := srv.S3.(*S3); ok && fake.Endpoint == "" {
+		fake.Endpoint = srv.Endpoint()
+	}
+	return srv.Endpoint(), nil
+}
+
+// Endpoint returns the URL the server is listening on, or "" if it hasn't been started
+func (srv *Server) Endpoint() string {
+	if srv.listener == nil {
+		return ""
+	}
+	return fmt.Sprintf("http://%s", srv.listener.Addr())
+}
+
+// Close stops a server started with Start
+func (srv *Server) Close() error {
+	if srv.httpServer == nil {
+		return nil
+	}
+	return srv.httpServer.Close()
+}
+
+// ServeHTTP routes requests to the Lambda, SSM or S3 handlers,
+// this allows the Server to be mounted in any http.Server or httptest.Server
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, lambdaInvokePrefix):
+		srv.serveLambda(w, r)
+	case strings.HasPrefix(r.Header.Get("X-Amz-Target"), ssmTargetPrefix):
+		srv.serveSSM(w, r)
+	default:
+		srv.serveS3(w, r)
+	}
+}
+
+func (srv *Server) serveLambda(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, lambdaInvokePrefix), "/invocations")
+	if srv.Lambda == nil || r.Method != http.MethodPost || name == r.URL.Path {
+		writeRestJSONError(w, awserr.New("NotImplemented", "lambda: "+r.Method+" "+r.URL.Path, nil))
+		return
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeRestJSONError(w, err)
+		return
+	}
+	input := &lambda.InvokeInput{
+		FunctionName: aws.String(name),
+		Payload:      payload,
+	}
+	if v := r.Header.Get("X-Amz-Invocation-Type"); v != "" {
+		input.InvocationType = aws.String(v)
+	}
+	if v := r.URL.Query().Get("Qualifier"); v != "" {
+		input.Qualifier = aws.String(v)
+	}
+	output, err := srv.Lambda.Invoke(input)
+	if err != nil {
+		writeRestJSONError(w, err)
+		return
+	}
+	if output.FunctionError != nil {
+		w.Header().Set("X-Amz-Function-Error", *output.FunctionError)
+	}
+	if output.ExecutedVersion != nil {
+		w.Header().Set("X-Amz-Executed-Version", *output.ExecutedVersion)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(aws.Int64Value(output.StatusCode)))
+	_, _ = w.Write(output.Payload)
+}
+
+func (srv *Server) serveSSM(w http.ResponseWriter, r *http.Request) {
+	if srv.SSM == nil {
+		writeJSONRPCError(w, awserr.New("UnknownOperationException", "ssm is not configured", nil))
+		return
+	}
+	var (
+		input  interface{}
+		invoke func() (interface{}, error)
+	)
+	switch op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), ssmTargetPrefix); op {
+	case "GetParameter":
+		in := &ssmParameterRequest{}
+		input, invoke = in, func() (interface{}, error) {
+			output, err := srv.SSM.GetParameter(&ssm.GetParameterInput{Name: in.Name, WithDecryption: in.WithDecryption})
+			if err != nil {
+				return nil, err
+			}
+			return &ssmGetParameterResponse{Parameter: newSSMParameter(output.Parameter)}, nil
+		}
+	case "GetParametersByPath":
+		in := &ssmParameterRequest{}
+		input, invoke = in, func() (interface{}, error) {
+			output, err := srv.SSM.GetParametersByPath(&ssm.GetParametersByPathInput{
+				Path: in.Path, Recursive: in.Recursive, WithDecryption: in.WithDecryption,
+			})
+			if err != nil {
+				return nil, err
+			}
+			resp := &ssmGetParametersByPathResponse{Parameters: []*ssmParameter{}, NextToken: output.NextToken}
+			for _, param := range output.Parameters {
+				resp.Parameters = append(resp.Parameters, newSSMParameter(param))
+			}
+			return resp, nil
+		}
+	case "PutParameter":
+		in := &ssmParameterRequest{}
+		input, invoke = in, func() (interface{}, error) {
+			output, err := srv.SSM.PutParameter(&ssm.PutParameterInput{
+				Name: in.Name, Value: in.Value, Type: in.Type, Overwrite: in.Overwrite,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &ssmPutParameterResponse{Version: output.Version, Tier: output.Tier}, nil
+		}
+	case "DeleteParameter":
+		in := &ssmParameterRequest{}
+		input, invoke = in, func() (interface{}, error) {
+			if _, err := srv.SSM.DeleteParameter(&ssm.DeleteParameterInput{Name: in.Name}); err != nil {
+				return nil, err
+			}
+			return struct{}{}, nil
+		}
+	default:
+		writeJSONRPCError(w, awserr.New("UnknownOperationException", "ssm: unsupported operation "+op, nil))
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+		writeJSONRPCError(w, awserr.New("SerializationException", err.Error(), nil))
+		return
+	}
+	output, err := invoke()
+	if err != nil {
+		writeJSONRPCError(w, err)
+		return
+	}
+	body, err := json.Marshal(output)
+	if err != nil {
+		writeJSONRPCError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_, _ = w.Write(body)
+}
+
+// ssmParameterRequest holds the members of the supported SSM requests, each only sets the ones it uses
+type ssmParameterRequest struct {
+	Name           *string `json:"Name"`
+	Path           *string `json:"Path"`
+	Value          *string `json:"Value"`
+	Type           *string `json:"Type"`
+	Overwrite      *bool   `json:"Overwrite"`
+	Recursive      *bool   `json:"Recursive"`
+	WithDecryption *bool   `json:"WithDecryption"`
+}
+
+// ssmParameter is the wire format of ssm.Parameter, timestamps are epoch seconds
+type ssmParameter struct {
+	Name             *string  `json:"Name,omitempty"`
+	Type             *string  `json:"Type,omitempty"`
+	Value            *string  `json:"Value,omitempty"`
+	Version          *int64   `json:"Version,omitempty"`
+	LastModifiedDate *float64 `json:"LastModifiedDate,omitempty"`
+}
+
+func newSSMParameter(param *ssm.Parameter) *ssmParameter {
+	wire := &ssmParameter{Name: param.Name, Type: param.Type, Value: param.Value, Version: param.Version}
+	if param.LastModifiedDate != nil {
+		seconds := float64(param.LastModifiedDate.UnixNano()) / float64(time.Second)
+		wire.LastModifiedDate = &seconds
+	}
+	return wire
+}
+
+type ssmGetParameterResponse struct {
+	Parameter *ssmParameter `json:"Parameter"`
+}
+
+type ssmGetParametersByPathResponse struct {
+	Parameters []*ssmParameter `json:"Parameters"`
+	NextToken  *string         `json:"NextToken,omitempty"`
+}
+
+type ssmPutParameterResponse struct {
+	Version *int64  `json:"Version,omitempty"`
+	Tier    *string `json:"Tier,omitempty"`
+}
+
+func (srv *Server) serveS3(w http.ResponseWriter, r *http.Request) {
+	bucket, key := splitS3Path(r.URL.Path)
+	if srv.S3 == nil || bucket == "" {
+		writeS3Error(w, r, awserr.New("NotImplemented", "s3: "+r.Method+" "+r.URL.Path, nil))
+		return
+	}
+	if key == "" {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			srv.serveS3List(w, r, bucket)
+			return
+		}
+		writeS3Error(w, r, awserr.New("NotImplemented", "s3: bucket operation "+r.Method+" is not supported", nil))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		output, err := srv.S3.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			writeS3Error(w, r, err)
+			return
+		}
+		defer output.Body.Close()
+		body, err := ioutil.ReadAll(output.Body)
+		if err != nil {
+			writeS3Error(w, r, err)
+			return
+		}
+		writeS3ObjectHeaders(w, output.ContentType, output.ETag, output.LastModified, output.Metadata)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body)
+	case http.MethodHead:
+		output, err := srv.S3.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			writeS3Error(w, r, err)
+			return
+		}
+		writeS3ObjectHeaders(w, output.ContentType, output.ETag, output.LastModified, output.Metadata)
+		w.Header().Set("Content-Length", strconv.FormatInt(aws.Int64Value(output.ContentLength), 10))
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, r, err)
+			return
+		}
+		input := &s3.PutObjectInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(key),
+			Body:     bytes.NewReader(body),
+			Metadata: map[string]*string{},
+		}
+		if v := r.Header.Get("Content-Type"); v != "" {
+			input.ContentType = aws.String(v)
+		}
+		for name, values := range r.Header {
+			if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-meta-") && len(values) > 0 {
+				input.Metadata[strings.TrimPrefix(lower, "x-amz-meta-")] = aws.String(values[0])
+			}
+		}
+		output, err := srv.S3.PutObject(input)
+		if err != nil {
+			writeS3Error(w, r, err)
+			return
+		}
+		if output.ETag != nil {
+			w.Header().Set("ETag", *output.ETag)
+		}
+	case http.MethodDelete:
+		if _, err := srv.S3.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); err != nil {
+			writeS3Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, r, awserr.New("NotImplemented", "s3: object operation "+r.Method+" is not supported", nil))
+	}
+}
+
+// s3ListBucketResult is the XML body of a ListObjectsV2 response
+type s3ListBucketResult struct {
+	XMLName               xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int64            `xml:"KeyCount"`
+	MaxKeys               int64            `xml:"MaxKeys"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Contents              []s3ListContents `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3ListContents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (srv *Server) serveS3List(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket)}
+	for param, field := range map[string]**string{
+		"prefix":             &input.Prefix,
+		"delimiter":          &input.Delimiter,
+		"start-after":        &input.StartAfter,
+		"continuation-token": &input.ContinuationToken,
+	} {
+		if query.Has(param) {
+			*field = aws.String(query.Get(param))
+		}
+	}
+	if v := query.Get("max-keys"); v != "" {
+		maxKeys, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeS3Error(w, r, awserr.New("InvalidArgument", "max-keys must be an integer", err))
+			return
+		}
+		input.MaxKeys = aws.Int64(maxKeys)
+	}
+	output, err := srv.S3.ListObjectsV2(input)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	result := &s3ListBucketResult{
+		Name:                  bucket,
+		Prefix:                aws.StringValue(output.Prefix),
+		Delimiter:             aws.StringValue(output.Delimiter),
+		StartAfter:            aws.StringValue(output.StartAfter),
+		ContinuationToken:     aws.StringValue(output.ContinuationToken),
+		NextContinuationToken: aws.StringValue(output.NextContinuationToken),
+		KeyCount:              aws.Int64Value(output.KeyCount),
+		MaxKeys:               aws.Int64Value(output.MaxKeys),
+		IsTruncated:           aws.BoolValue(output.IsTruncated),
+	}
+	for _, obj := range output.Contents {
+		result.Contents = append(result.Contents, s3ListContents{
+			Key:          aws.StringValue(obj.Key),
+			LastModified: aws.TimeValue(obj.LastModified).UTC().Format(s3TimeFormat),
+			ETag:         aws.StringValue(obj.ETag),
+			Size:         aws.Int64Value(obj.Size),
+			StorageClass: aws.StringValue(obj.StorageClass),
+		})
+	}
+	for _, cp := range output.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: aws.StringValue(cp.Prefix)})
+	}
+	body, err := xml.Marshal(result)
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(append([]byte(xml.Header), body...))
+}
+
+// splitS3Path splits a path-style request path into its bucket and key
+func splitS3Path(path string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func writeS3ObjectHeaders(w http.ResponseWriter, contentType *string, etag *string, lastModified *time.Time, metadata map[string]*string) {
+	if contentType != nil {
+		w.Header().Set("Content-Type", *contentType)
+	}
+	if etag != nil {
+		w.Header().Set("ETag", *etag)
+	}
+	if lastModified != nil {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	for name, value := range metadata {
+		w.Header().Set("X-Amz-Meta-"+name, aws.StringValue(value))
+	}
+}
+
+// errorCode returns the AWS error code and message for err
+// along with the HTTP status the real services would respond with
+func errorCode(err error) (string, string, int) {
+	aErr, ok := err.(awserr.Error)
+	if !ok {
+		return "InternalError", err.Error(), http.StatusInternalServerError
+	}
+	switch aErr.Code() {
+	case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, lambda.ErrCodeResourceNotFoundException:
+		return aErr.Code(), aErr.Message(), http.StatusNotFound
+	case "NotImplemented":
+		return aErr.Code(), aErr.Message(), http.StatusNotImplemented
+	default:
+		return aErr.Code(), aErr.Message(), http.StatusBadRequest
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, err error) {
+	code, message, status := errorCode(err)
+	body, _ := xml.Marshal(&struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string   `xml:"Code"`
+		Message  string   `xml:"Message"`
+		Resource string   `xml:"Resource"`
+	}{Code: code, Message: message, Resource: r.URL.Path})
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(append([]byte(xml.Header), body...))
+	}
+}
+
+func writeRestJSONError(w http.ResponseWriter, err error) {
+	code, message, status := errorCode(err)
+	body, _ := json.Marshal(map[string]string{"Type": "User", "message": message})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-Errortype", code)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func writeJSONRPCError(w http.ResponseWriter, err error) {
+	code, message, status := errorCode(err)
+	body, _ := json.Marshal(map[string]string{"__type": code, "message": message})
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,182 @@
+package fakeaws_test
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+
+	"code.agarg.me/schism/commonLib"
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// startServer runs a fakeaws.Server for the duration of the test
+// and points every commonLib client at it
+func startServer(t *testing.T, srv *fakeaws.Server) {
+	t.Helper()
+	endpoint, err := srv.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	t.Setenv(commonLib.EndpointEnvVar, endpoint)
+	t.Setenv("AWS_ACCESS_KEY_ID", "fakeaws")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "fakeaws")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+}
+
+func TestServer_S3(t *testing.T) {
+	dirS3, err := fakeaws.NewDirS3(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s3Svc := range map[string]*fakeaws.S3{
+		"memory":    fakeaws.NewS3(testBucket),
+		"directory": dirS3,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := s3Svc.AddBucket(testBucket); err != nil {
+				t.Fatal(err)
+			}
+			startServer(t, &fakeaws.Server{S3: s3Svc})
+			client := commonLib.S3Client("us-east-1")
+
+			certKey := protocol.S3CertStoragePrefix + "host:55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d.json"
+			for _, key := range []string{certKey, protocol.S3CaPubkeyPrefix + "host.json", protocol.S3CaPubkeyPrefix + "user.json"} {
+				_, err := client.PutObject(&s3.PutObjectInput{
+					Bucket:      aws.String(testBucket),
+					Key:         aws.String(key),
+					Body:        strings.NewReader(`{"certificate_type":"host","identity":"test.example.com"}`),
+					ContentType: aws.String("application/json"),
+					Metadata:    map[string]*string{"schism-version": aws.String("1")},
+				})
+				if err != nil {
+					t.Fatalf("PutObject(%s) error = %v", key, err)
+				}
+			}
+
+			got, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String(testBucket), Key: aws.String(certKey)})
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			body, _ := ioutil.ReadAll(got.Body)
+			if string(body) != `{"certificate_type":"host","identity":"test.example.com"}` {
+				t.Errorf("GetObject() body = %s", body)
+			}
+			if aws.StringValue(got.ContentType) != "application/json" {
+				t.Errorf("GetObject() ContentType = %v", aws.StringValue(got.ContentType))
+			}
+			if aws.StringValue(got.Metadata["Schism-Version"]) != "1" {
+				t.Errorf("GetObject() Metadata = %v", aws.StringValueMap(got.Metadata))
+			}
+
+			lk := &protocol.LookupKey{Id: "55e8182e", Type: "h"}
+			if err = lk.Expand(client, testBucket, ""); err != nil {
+				t.Fatalf("LookupKey.Expand() error = %v", err)
+			}
+			cert := &protocol.SignedCertificateS3Object{}
+			if err = cert.LoadObject(client, testBucket, certKey); err != nil || cert.Identity != "test.example.com" {
+				t.Errorf("LoadObject() = %+v, error = %v", cert, err)
+			}
+
+			var keys []string
+			err = client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+				Bucket:  aws.String(testBucket),
+				Prefix:  aws.String(protocol.S3CaPubkeyPrefix),
+				MaxKeys: aws.Int64(1),
+			}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+				for _, obj := range page.Contents {
+					keys = append(keys, *obj.Key)
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatalf("ListObjectsV2Pages() error = %v", err)
+			}
+			if want := []string{"CA-Pubkeys/host.json", "CA-Pubkeys/user.json"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("ListObjectsV2Pages() = %v, want %v", keys, want)
+			}
+
+			if _, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(testBucket), Key: aws.String(certKey)}); err != nil {
+				t.Fatalf("DeleteObject() error = %v", err)
+			}
+			_, err = client.GetObject(&s3.GetObjectInput{Bucket: aws.String(testBucket), Key: aws.String(certKey)})
+			if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != s3.ErrCodeNoSuchKey {
+				t.Errorf("GetObject() after delete error = %v, want %s", err, s3.ErrCodeNoSuchKey)
+			}
+		})
+	}
+}
+
+func TestServer_Lambda(t *testing.T) {
+	lambdaSvc := fakeaws.NewLambda()
+	lambdaSvc.Register("schism-ca", fakeaws.JSONHandler(func(req protocol.RequestSSHCertLambdaPayload) (*protocol.RequestSSHCertLambdaResponse, error) {
+		return &protocol.RequestSSHCertLambdaResponse{
+			CertificateType: req.CertificateType,
+			LookupKey:       protocol.GenerateLookupKey(req.Identity, req.Principals, req.CertificateType).String(),
+		}, nil
+	}))
+	startServer(t, &fakeaws.Server{Lambda: lambdaSvc})
+	client := commonLib.LambdaClient("us-east-1")
+
+	got, err := client.Invoke(&lambda.InvokeInput{
+		FunctionName: aws.String("schism-ca"),
+		Payload:      []byte(`{"certificate_type":"host","certificate_identity":"test.example.com","certificate_principals":["test.example.com"]}`),
+	})
+	if err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	if want := `{"certificate_type":"host","lookup_key":"host:55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d"}`; string(got.Payload) != want {
+		t.Errorf("Invoke() payload = %s, want %s", got.Payload, want)
+	}
+
+	_, err = client.Invoke(&lambda.InvokeInput{FunctionName: aws.String("missing"), Payload: []byte(`{}`)})
+	if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != lambda.ErrCodeResourceNotFoundException {
+		t.Errorf("Invoke() error = %v, want %s", err, lambda.ErrCodeResourceNotFoundException)
+	}
+}
+
+func TestServer_SSM(t *testing.T) {
+	ssmSvc := fakeaws.NewSSM()
+	ssmSvc.Set("/schism/host-ca", "secret")
+	startServer(t, &fakeaws.Server{SSM: ssmSvc})
+	client := commonLib.SSMClient("us-east-1")
+
+	got, err := client.GetParameter(&ssm.GetParameterInput{Name: aws.String("/schism/host-ca"), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		t.Fatalf("GetParameter() error = %v", err)
+	}
+	if aws.StringValue(got.Parameter.Value) != "secret" {
+		t.Errorf("GetParameter() = %v, want secret", aws.StringValue(got.Parameter.Value))
+	}
+
+	_, err = client.GetParameter(&ssm.GetParameterInput{Name: aws.String("/schism/user-ca")})
+	if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != ssm.ErrCodeParameterNotFound {
+		t.Errorf("GetParameter() error = %v, want %s", err, ssm.ErrCodeParameterNotFound)
+	}
+
+	put, err := client.PutParameter(&ssm.PutParameterInput{
+		Name: aws.String("/schism/user-ca"), Value: aws.String("other"), Type: aws.String(ssm.ParameterTypeSecureString),
+	})
+	if err != nil || aws.Int64Value(put.Version) != 1 {
+		t.Fatalf("PutParameter() = %v, error = %v", put, err)
+	}
+	byPath, err := client.GetParametersByPath(&ssm.GetParametersByPathInput{Path: aws.String("/schism")})
+	if err != nil {
+		t.Fatalf("GetParametersByPath() error = %v", err)
+	}
+	if len(byPath.Parameters) != 2 || aws.StringValue(byPath.Parameters[1].Value) != "other" || byPath.Parameters[1].LastModifiedDate.IsZero() {
+		t.Errorf("GetParametersByPath() = %v", byPath.Parameters)
+	}
+	if _, err = client.DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String("/schism/user-ca")}); err != nil {
+		t.Errorf("DeleteParameter() error = %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package fakeaws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// SSMParameter is a single parameter stored in the fake SSM service
+type SSMParameter struct {
+	Value        string
+	Type         string
+	Version      int64
+	LastModified time.Time
+}
+
+// SSM is an in-memory implementation of the parts of ssmiface.SSMAPI used by Schism
+//
+// SecureString parameters are stored in plain text, WithDecryption is accepted but ignored
+type SSM struct {
+	ssmiface.SSMAPI
+
+	// Now is used to stamp LastModifiedDate on parameters, defaults to time.Now
+	Now func() time.Time
+
+	mu         sync.RWMutex
+	parameters map[string]*SSMParameter
+}
+
+// NewSSM returns an empty fake SSM service
+func NewSSM() *SSM {
+	return &SSM{
+		Now:        time.Now,
+		parameters: map[string]*SSMParameter{},
+	}
+}
+
+// Set stores a SecureString parameter, overwriting any existing value
+//
+// This is a shortcut for seeding test data without building a PutParameterInput
+func (s *SSM) Set(name string, value string) {
+	_, _ = s.PutParameter(&ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Value:     aws.String(value),
+		Type:      aws.String(ssm.ParameterTypeSecureString),
+		Overwrite: aws.Bool(true),
+	})
+}
+
+// PutParameter stores a parameter, existing parameters are only replaced when Overwrite is set
+func (s *SSM) PutParameter(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	name := aws.StringValue(input.Name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	param, exists := s.parameters[name]
+	if exists && !aws.BoolValue(input.Overwrite) {
+		return nil, awserr.New(ssm.ErrCodeParameterAlreadyExists, fmt.Sprintf("The parameter already exists: %s", name), nil)
+	}
+	if !exists {
+		param = &SSMParameter{Type: ssm.ParameterTypeString}
+		s.parameters[name] = param
+	}
+	if input.Type != nil {
+		param.Type = aws.StringValue(input.Type)
+	}
+	param.Value = aws.StringValue(input.Value)
+	param.Version++
+	param.LastModified = s.Now()
+	return &ssm.PutParameterOutput{
+		Tier:    aws.String(ssm.ParameterTierStandard),
+		Version: aws.Int64(param.Version),
+	}, nil
+}
+
+// GetParameter returns the stored parameter or an ssm.ErrCodeParameterNotFound error
+func (s *SSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	name := aws.StringValue(input.Name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	param, ok := s.parameters[name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, fmt.Sprintf("Parameter %s not found.", name), nil)
+	}
+	return &ssm.GetParameterOutput{Parameter: param.output(name)}, nil
+}
+
+// GetParametersByPath returns every parameter below path, sub-paths are only included when Recursive is set
+func (s *SSM) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	path := strings.TrimSuffix(aws.StringValue(input.Path), "/") + "/"
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var names []string
+	for name := range s.parameters {
+		if !strings.HasPrefix(name, path) {
+			continue
+		}
+		if !aws.BoolValue(input.Recursive) && strings.Contains(name[len(path):], "/") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	output := &ssm.GetParametersByPathOutput{}
+	for _, name := range names {
+		output.Parameters = append(output.Parameters, s.parameters[name].output(name))
+	}
+	return output, nil
+}
+
+// DeleteParameter removes a parameter or returns an ssm.ErrCodeParameterNotFound error
+func (s *SSM) DeleteParameter(input *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	name := aws.StringValue(input.Name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.parameters[name]; !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, fmt.Sprintf("Parameter %s not found.", name), nil)
+	}
+	delete(s.parameters, name)
+	return &ssm.DeleteParameterOutput{}, nil
+}
+
+func (p *SSMParameter) output(name string) *ssm.Parameter {
+	return &ssm.Parameter{
+		Name:             aws.String(name),
+		Type:             aws.String(p.Type),
+		Value:            aws.String(p.Value),
+		Version:          aws.Int64(p.Version),
+		LastModifiedDate: aws.Time(p.LastModified),
+	}
+}
@@ -2,6 +2,12 @@ module code.agarg.me/schism/commonLib
 
 go 1.18
 
-require github.com/aws/aws-sdk-go v1.44.19
+require (
+	github.com/aws/aws-sdk-go v1.44.19
+	golang.org/x/crypto v0.9.0
+)
 
-require github.com/jmespath/go-jmespath v0.4.0 // indirect
+require (
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	golang.org/x/sys v0.8.0 // indirect
+)
@@ -0,0 +1,154 @@
+package protocol
+
+import (
+	"fmt"
+	"sync"
+
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// LambdaMaxPayloadBytes is the largest request payload accepted by a synchronous Lambda invocation
+const LambdaMaxPayloadBytes = 6 * 1024 * 1024
+
+// DefaultBatchConcurrency is how many requests ProcessBatch signs at once when not told otherwise
+const DefaultBatchConcurrency = 8
+
+// RequestSSHCertBatchLambdaPayload is used to request many certificates in one lambda invocation
+type RequestSSHCertBatchLambdaPayload struct {
+	Requests []*RequestSSHCertLambdaPayload `json:"requests"`
+}
+
+// RequestSSHCertBatchLambdaResponse holds one result per request, in request order
+type RequestSSHCertBatchLambdaResponse struct {
+	Results []*BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of a single request in a batch, exactly one of Response or Error is set
+type BatchResult struct {
+	// Position of the request in the batch
+	Index    int                           `json:"index"`
+	Response *RequestSSHCertLambdaResponse `json:"response,omitempty"`
+	Error    *ProtocolError                `json:"error,omitempty"`
+}
+
+// ProcessBatch runs handler for every request in the batch,
+// with at most concurrency requests in flight (DefaultBatchConcurrency if <= 0)
+//
+// Errors returned by handler are converted with AsProtocolError and reported per request,
+// a failing request doesn't stop the rest of the batch
+func ProcessBatch(
+	batch *RequestSSHCertBatchLambdaPayload,
+	concurrency int,
+	handler func(*RequestSSHCertLambdaPayload) (*RequestSSHCertLambdaResponse, error),
+) *RequestSSHCertBatchLambdaResponse {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	results := make([]*BatchResult, len(batch.Requests))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, req := range batch.Requests {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, req *RequestSSHCertLambdaPayload) {
+			defer func() { <-sem; wg.Done() }()
+			result := &BatchResult{Index: i}
+			if req == nil {
+				result.Error = NewProtocolError(ErrCodeInvalidRequest, "request %d is empty", i)
+			} else if resp, err := handler(req); err != nil {
+				result.Error = AsProtocolError(err)
+			} else {
+				result.Response = resp
+			}
+			results[i] = result
+		}(i, req)
+	}
+	wg.Wait()
+	return &RequestSSHCertBatchLambdaResponse{Results: results}
+}
+
+// SplitBatch splits requests into batches whose marshaled payload stays under maxBytes
+// (LambdaMaxPayloadBytes if <= 0) while keeping the request order
+//
+// Returns an error if a single request is too large to fit in a batch on its own
+func SplitBatch(requests []*RequestSSHCertLambdaPayload, maxBytes int) ([]*RequestSSHCertBatchLambdaPayload, error) {
+	if maxBytes <= 0 {
+		maxBytes = LambdaMaxPayloadBytes
+	}
+	// {"requests":[ ... ]}
+	overhead := len(`{"requests":[]}`)
+	var (
+		batches []*RequestSSHCertBatchLambdaPayload
+		current = &RequestSSHCertBatchLambdaPayload{}
+		size    = overhead
+	)
+	for i, req := range requests {
+		raw, err := json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal request %d: %w", i, err)
+		}
+		if overhead+len(raw) > maxBytes {
+			return nil, fmt.Errorf("request %d is %d bytes, too large for a %d byte payload", i, len(raw), maxBytes)
+		}
+		// the comma separating requests
+		itemSize := len(raw)
+		if len(current.Requests) > 0 {
+			itemSize++
+		}
+		if size+itemSize > maxBytes {
+			batches = append(batches, current)
+			current, size, itemSize = &RequestSSHCertBatchLambdaPayload{}, overhead, len(raw)
+		}
+		current.Requests = append(current.Requests, req)
+		size += itemSize
+	}
+	if len(current.Requests) > 0 {
+		batches = append(batches, current)
+	}
+	return batches, nil
+}
+
+// InvokeBatch splits requests with SplitBatch, invokes the named lambda function once per batch
+// and returns the results for every request, in request order with Index relative to requests
+//
+// Returns an error if an invocation fails outright, per request failures are reported in the results
+func InvokeBatch(lambdaSvc lambdaiface.LambdaAPI, functionName string, requests []*RequestSSHCertLambdaPayload) ([]*BatchResult, error) {
+	batches, err := SplitBatch(requests, LambdaMaxPayloadBytes)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*BatchResult, 0, len(requests))
+	for _, batch := range batches {
+		payload, err := json.Marshal(batch)
+		if err != nil {
+			return nil, err
+		}
+		output, err := lambdaSvc.Invoke(&lambda.InvokeInput{
+			FunctionName: aws.String(functionName),
+			Payload:      payload,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if output.FunctionError != nil {
+			return nil, fmt.Errorf("batch invocation of %s failed (%s): %s", functionName, *output.FunctionError, output.Payload)
+		}
+		resp := &RequestSSHCertBatchLambdaResponse{}
+		if err = json.Unmarshal(output.Payload, resp); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal batch response: %w", err)
+		}
+		if len(resp.Results) != len(batch.Requests) {
+			return nil, fmt.Errorf("batch response has %d results for %d requests", len(resp.Results), len(batch.Requests))
+		}
+		offset := len(results)
+		for _, result := range resp.Results {
+			result.Index += offset
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
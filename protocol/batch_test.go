@@ -0,0 +1,162 @@
+package protocol_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"encoding/json"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// signHandler is a stand-in for the CA, hosts named "bad*" are rejected
+func signHandler(req *protocol.RequestSSHCertLambdaPayload) (*protocol.RequestSSHCertLambdaResponse, error) {
+	if strings.HasPrefix(req.Identity, "bad") {
+		return nil, protocol.NewProtocolError(protocol.ErrCodeInvalidRequest, "identity %s is not allowed", req.Identity)
+	}
+	return &protocol.RequestSSHCertLambdaResponse{
+		CertificateType: req.CertificateType,
+		LookupKey:       protocol.GenerateLookupKey(req.Identity, req.Principals, req.CertificateType).String(),
+	}, nil
+}
+
+func hostRequests(idents ...string) []*protocol.RequestSSHCertLambdaPayload {
+	var requests []*protocol.RequestSSHCertLambdaPayload
+	for _, ident := range idents {
+		requests = append(requests, &protocol.RequestSSHCertLambdaPayload{
+			CertificateType: protocol.HostCertificate,
+			Identity:        ident,
+			Principals:      []string{ident},
+		})
+	}
+	return requests
+}
+
+func TestProcessBatch(t *testing.T) {
+	var (
+		mu                sync.Mutex
+		inFlight, maxSeen int
+	)
+	handler := func(req *protocol.RequestSSHCertLambdaPayload) (*protocol.RequestSSHCertLambdaResponse, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return signHandler(req)
+	}
+	batch := &protocol.RequestSSHCertBatchLambdaPayload{
+		Requests: append(hostRequests("test.example.com", "bad.example.com", "a.example.com", "b.example.com", "c.example.com"), nil),
+	}
+	got := protocol.ProcessBatch(batch, 2, handler)
+
+	if len(got.Results) != len(batch.Requests) {
+		t.Fatalf("ProcessBatch() returned %d results, want %d", len(got.Results), len(batch.Requests))
+	}
+	if maxSeen > 2 {
+		t.Errorf("ProcessBatch() ran %d requests at once, want at most 2", maxSeen)
+	}
+	want0 := &protocol.BatchResult{
+		Index: 0,
+		Response: &protocol.RequestSSHCertLambdaResponse{
+			CertificateType: protocol.HostCertificate,
+			LookupKey:       "host:" + hostTestExampleComKey,
+		},
+	}
+	if !reflect.DeepEqual(got.Results[0], want0) {
+		t.Errorf("ProcessBatch() result 0 = %+v, want %+v", got.Results[0], want0)
+	}
+	if err := got.Results[1].Error; err == nil || err.Code != protocol.ErrCodeInvalidRequest || got.Results[1].Response != nil {
+		t.Errorf("ProcessBatch() result 1 = %+v, want an InvalidRequest error", got.Results[1])
+	}
+	if err := got.Results[5].Error; err == nil || err.Code != protocol.ErrCodeInvalidRequest {
+		t.Errorf("ProcessBatch() result 5 = %+v, want an InvalidRequest error", got.Results[5])
+	}
+	for i, result := range got.Results {
+		if result.Index != i {
+			t.Errorf("ProcessBatch() result %d has Index %d", i, result.Index)
+		}
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	requests := hostRequests("a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com")
+	single, _ := json.Marshal(&protocol.RequestSSHCertBatchLambdaPayload{Requests: requests[:1]})
+	double, _ := json.Marshal(&protocol.RequestSSHCertBatchLambdaPayload{Requests: requests[:2]})
+	tests := []struct {
+		name     string
+		maxBytes int
+		want     []int
+		wantErr  bool
+	}{
+		{name: "everything fits", maxBytes: 0, want: []int{5}},
+		{name: "exactly two per batch", maxBytes: len(double), want: []int{2, 2, 1}},
+		{name: "one per batch", maxBytes: len(double) - 1, want: []int{1, 1, 1, 1, 1}},
+		{name: "request too large", maxBytes: len(single) - 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := protocol.SplitBatch(requests, tt.maxBytes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var sizes []int
+			var flat []*protocol.RequestSSHCertLambdaPayload
+			for _, batch := range got {
+				sizes = append(sizes, len(batch.Requests))
+				flat = append(flat, batch.Requests...)
+				if raw, _ := json.Marshal(batch); tt.maxBytes > 0 && len(raw) > tt.maxBytes {
+					t.Errorf("SplitBatch() batch is %d bytes, max %d", len(raw), tt.maxBytes)
+				}
+			}
+			if !reflect.DeepEqual(sizes, tt.want) {
+				t.Errorf("SplitBatch() batch sizes = %v, want %v", sizes, tt.want)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(flat, requests) {
+				t.Errorf("SplitBatch() did not keep every request in order")
+			}
+		})
+	}
+}
+
+func TestInvokeBatch(t *testing.T) {
+	lambdaSvc := fakeaws.NewLambda()
+	lambdaSvc.Register("schism-ca-batch", fakeaws.JSONHandler(func(batch protocol.RequestSSHCertBatchLambdaPayload) (*protocol.RequestSSHCertBatchLambdaResponse, error) {
+		return protocol.ProcessBatch(&batch, 0, signHandler), nil
+	}))
+	var idents []string
+	for i := 0; i < 20; i++ {
+		idents = append(idents, fmt.Sprintf("host%d.example.com", i))
+	}
+	idents[7] = "bad.example.com"
+
+	got, err := protocol.InvokeBatch(lambdaSvc, "schism-ca-batch", hostRequests(idents...))
+	if err != nil {
+		t.Fatalf("InvokeBatch() error = %v", err)
+	}
+	if len(got) != len(idents) {
+		t.Fatalf("InvokeBatch() returned %d results, want %d", len(got), len(idents))
+	}
+	for i, result := range got {
+		if result.Index != i {
+			t.Errorf("InvokeBatch() result %d has Index %d", i, result.Index)
+		}
+		if (result.Error != nil) != (i == 7) {
+			t.Errorf("InvokeBatch() result %d = %+v", i, result)
+		}
+	}
+
+	if _, err = protocol.InvokeBatch(lambdaSvc, "missing", hostRequests("a.example.com")); err == nil {
+		t.Error("InvokeBatch() expected an error for a missing function")
+	}
+}
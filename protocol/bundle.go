@@ -0,0 +1,331 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"archive/tar"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
+)
+
+// BundleVersion is the manifest format written by ExportBundle
+const BundleVersion = 1
+
+// Names of the entries in a bundle, objects are stored below bundleObjectsDir by their key relative to the prefix
+const (
+	bundleManifestName  = "manifest.json"
+	bundleSignatureName = "manifest.sig"
+	bundleObjectsDir    = "objects/"
+)
+
+// SourceLastModifiedMetadataKey is the S3 user metadata key ImportBundle stamps with the LastModified (RFC 3339)
+// of the exported copy, so later imports compare timestamps from the same store
+const SourceLastModifiedMetadataKey = "Schism-Source-Last-Modified"
+
+// DefaultBundlePrefixes are the subprefixes exported when ExportOptions.Prefixes is empty
+var DefaultBundlePrefixes = []string{S3CaPubkeyPrefix, S3CertStoragePrefix}
+
+// ErrBundleInvalid is returned (wrapped) when a bundle fails verification
+var ErrBundleInvalid = errors.New("bundle is invalid")
+
+// BundleManifest lists every object in a bundle, it is signed when the bundle is exported
+type BundleManifest struct {
+	Version   int       `json:"version"`
+	CreatedOn time.Time `json:"created_on"`
+	// Subprefixes the bundle was exported from, every object key starts with one of them
+	Prefixes []string      `json:"prefixes"`
+	Objects  []BundleEntry `json:"objects"`
+}
+
+// BundleEntry describes a single object in a bundle
+type BundleEntry struct {
+	// Object key relative to the store prefix
+	Key string `json:"key"`
+	// Hex sha256 of the object body
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	ContentType  string    `json:"content_type,omitempty"`
+	// User metadata, kept so checksums and signatures (see SaveSignedObject) survive the trip
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// bundleSignature is the content of manifest.sig
+type bundleSignature struct {
+	Signer    string `json:"signer"`
+	Signature string `json:"signature"`
+}
+
+// ExportOptions controls what ExportBundle writes
+type ExportOptions struct {
+	// Subprefixes to export, DefaultBundlePrefixes if empty
+	Prefixes []string
+	// Stamped on the manifest, defaults to time.Now()
+	Now time.Time
+}
+
+// ImportResult reports what ImportBundle did with every object in the bundle
+type ImportResult struct {
+	// Keys written to the store
+	Imported []string
+	// Keys left alone because the store already has an identical, newer or locally written object
+	Skipped []string
+}
+
+// ExportBundle writes every object under the exported subprefixes of prefix
+// to w as a gzipped tar, with a manifest of their hashes signed by signer
+func ExportBundle(s3Svc s3iface.S3API, s3Bucket string, prefix string, w io.Writer, signer ssh.Signer, opts *ExportOptions) error {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	manifest := &BundleManifest{Version: BundleVersion, CreatedOn: opts.Now, Prefixes: opts.Prefixes}
+	if manifest.CreatedOn.IsZero() {
+		manifest.CreatedOn = time.Now()
+	}
+	manifest.CreatedOn = manifest.CreatedOn.UTC()
+	if len(manifest.Prefixes) == 0 {
+		manifest.Prefixes = DefaultBundlePrefixes
+	}
+
+	bodies := map[string][]byte{}
+	for _, subPrefix := range manifest.Prefixes {
+		keys, err := ListObjectKeys(s3Svc, s3Bucket, prefix+subPrefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			entry, body, err := exportObject(s3Svc, s3Bucket, key)
+			if err != nil {
+				return err
+			}
+			entry.Key = strings.TrimPrefix(key, prefix)
+			bodies[entry.Key] = body
+			manifest.Objects = append(manifest.Objects, *entry)
+		}
+	}
+
+	rawManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal bundle manifest: %w", err)
+	}
+	payload, err := signedPayload(bundleManifestName, rawManifest)
+	if err != nil {
+		return err
+	}
+	sig, err := signer.Sign(nil, payload)
+	if err != nil {
+		return fmt.Errorf("unable to sign bundle manifest: %w", err)
+	}
+	rawSig, err := json.Marshal(&bundleSignature{
+		Signer:    ssh.FingerprintSHA256(signer.PublicKey()),
+		Signature: base64.StdEncoding.EncodeToString(ssh.Marshal(sig)),
+	})
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	type bundleFile struct {
+		name string
+		body []byte
+	}
+	files := []bundleFile{{bundleManifestName, rawManifest}, {bundleSignatureName, rawSig}}
+	for _, entry := range manifest.Objects {
+		files = append(files, bundleFile{bundleObjectsDir + entry.Key, bodies[entry.Key]})
+	}
+	for _, file := range files {
+		hdr := &tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.body)), ModTime: manifest.CreatedOn, Typeflag: tar.TypeReg}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err = tw.Write(file.body); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// exportObject fetches an object and describes it for the manifest
+//
+// Objects that were themselves imported keep the LastModified of their source, see SourceLastModifiedMetadataKey
+func exportObject(s3Svc s3iface.S3API, s3Bucket string, key string) (*BundleEntry, []byte, error) {
+	body, object, err := rawGetS3Object(s3Svc, s3Bucket, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &BundleEntry{
+		SHA256:       checksum(body),
+		Size:         int64(len(body)),
+		LastModified: aws.TimeValue(object.LastModified).UTC(),
+		ContentType:  aws.StringValue(object.ContentType),
+	}
+	if len(object.Metadata) > 0 {
+		entry.Metadata = aws.StringValueMap(object.Metadata)
+	}
+	if sourceLastModified, ok := metadataTime(object.Metadata, SourceLastModifiedMetadataKey); ok {
+		entry.LastModified = sourceLastModified
+	}
+	return entry, body, nil
+}
+
+// ImportBundle verifies a bundle written by ExportBundle and writes its objects below prefix
+//
+// The manifest must be signed by a key in anchor and every object must match its hash,
+// nothing is written unless the whole bundle verifies.
+// Objects already in the store are skipped if they are identical, were written to the store directly,
+// or were imported from a copy at least as new as the exported one.
+// Imported objects record the LastModified of the exported copy under SourceLastModifiedMetadataKey,
+// so the comparison never mixes the clocks of two stores.
+// Bundled objects larger than opts.MaxSize (DefaultLoadOptions if nil) make the bundle invalid
+func ImportBundle(s3Svc s3iface.S3API, s3Bucket string, prefix string, r io.Reader, anchor TrustAnchor, opts *LoadOptions) (*ImportResult, error) {
+	manifest, bodies, err := readBundle(r, anchor, opts.orDefault())
+	if err != nil {
+		return nil, err
+	}
+	result := &ImportResult{}
+	for _, entry := range manifest.Objects {
+		key := prefix + entry.Key
+		head, err := s3Svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(s3Bucket), Key: aws.String(key)})
+		if err == nil {
+			existing, _ := metadataValue(head.Metadata, ChecksumMetadataKey)
+			existingSource, imported := metadataTime(head.Metadata, SourceLastModifiedMetadataKey)
+			if strings.EqualFold(existing, entry.SHA256) || !imported || !entry.LastModified.After(existingSource) {
+				result.Skipped = append(result.Skipped, key)
+				continue
+			}
+		} else if !isNotFound(err) {
+			return result, err
+		}
+		metadata := aws.StringMap(entry.Metadata)
+		if metadata == nil {
+			metadata = map[string]*string{}
+		}
+		metadata[SourceLastModifiedMetadataKey] = aws.String(entry.LastModified.UTC().Format(time.RFC3339Nano))
+		input := &s3.PutObjectInput{
+			Bucket:   aws.String(s3Bucket),
+			Key:      aws.String(key),
+			Body:     bytes.NewReader(bodies[entry.Key]),
+			Metadata: metadata,
+		}
+		if entry.ContentType != "" {
+			input.ContentType = aws.String(entry.ContentType)
+		}
+		if _, err = s3Svc.PutObject(input); err != nil {
+			return result, err
+		}
+		result.Imported = append(result.Imported, key)
+	}
+	return result, nil
+}
+
+// metadataTime parses an RFC 3339 timestamp from S3 user metadata
+func metadataTime(metadata map[string]*string, key string) (time.Time, bool) {
+	value, ok := metadataValue(metadata, key)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	return t.UTC(), err == nil
+}
+
+// readBundle reads the whole bundle and verifies the manifest signature and every object hash
+func readBundle(r io.Reader, anchor TrustAnchor, opts LoadOptions) (*BundleManifest, map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBundleInvalid, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrBundleInvalid, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("%w: %s is not a regular file", ErrBundleInvalid, hdr.Name)
+		}
+		if _, dup := files[hdr.Name]; dup {
+			return nil, nil, fmt.Errorf("%w: %s appears twice", ErrBundleInvalid, hdr.Name)
+		}
+		if maxSize := opts.MaxSize; maxSize > 0 && hdr.Size > maxSize {
+			return nil, nil, fmt.Errorf("%w: %s: %s", ErrBundleInvalid, hdr.Name, ErrObjectTooLarge)
+		}
+		if files[hdr.Name], err = io.ReadAll(tr); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrBundleInvalid, err)
+		}
+	}
+
+	rawManifest, rawSig := files[bundleManifestName], files[bundleSignatureName]
+	if rawManifest == nil || rawSig == nil {
+		return nil, nil, fmt.Errorf("%w: missing %s or %s", ErrBundleInvalid, bundleManifestName, bundleSignatureName)
+	}
+	sig := &bundleSignature{}
+	if err = json.Unmarshal(rawSig, sig); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBundleInvalid, err)
+	}
+	metadata := map[string]*string{SignatureMetadataKey: &sig.Signature, SignerMetadataKey: &sig.Signer}
+	if err = verifyObject(anchor, metadata, bundleManifestName, rawManifest); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBundleInvalid, err)
+	}
+	manifest := &BundleManifest{}
+	if err = json.Unmarshal(rawManifest, manifest); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBundleInvalid, err)
+	}
+	if manifest.Version != BundleVersion {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrBundleInvalid, manifest.Version)
+	}
+
+	bodies := map[string][]byte{}
+	for _, entry := range manifest.Objects {
+		if !hasAnyPrefix(entry.Key, manifest.Prefixes) {
+			return nil, nil, fmt.Errorf("%w: %s is outside the exported prefixes", ErrBundleInvalid, entry.Key)
+		}
+		body, ok := files[bundleObjectsDir+entry.Key]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s is missing", ErrBundleInvalid, entry.Key)
+		}
+		if got := checksum(body); got != entry.SHA256 {
+			return nil, nil, fmt.Errorf("%w: %s has sha256 %s, manifest says %s", ErrBundleInvalid, entry.Key, got, entry.SHA256)
+		}
+		bodies[entry.Key] = body
+	}
+	if len(bodies)+2 != len(files) {
+		var extra []string
+		for name := range files {
+			if _, listed := bodies[strings.TrimPrefix(name, bundleObjectsDir)]; !listed && name != bundleManifestName && name != bundleSignatureName {
+				extra = append(extra, name)
+			}
+		}
+		sort.Strings(extra)
+		return nil, nil, fmt.Errorf("%w: %s not listed in the manifest", ErrBundleInvalid, strings.Join(extra, ", "))
+	}
+	return manifest, bodies, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
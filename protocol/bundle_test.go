@@ -0,0 +1,207 @@
+package protocol_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"archive/tar"
+	"compress/gzip"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestExportImportBundle(t *testing.T) {
+	exportedOn := time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC)
+	root := newTestSigner(t)
+	anchor := protocol.NewTrustAnchor(root.PublicKey())
+
+	source := fakeaws.NewS3(testValidBucket)
+	source.Now = func() time.Time { return exportedOn }
+	ca := &protocol.CAPublicKeyS3Object{
+		CertificateType: protocol.UserCertificate,
+		AuthorizedKey:   ssh.MarshalAuthorizedKey(newTestSigner(t).PublicKey()),
+	}
+	if err := protocol.SaveSignedObject(source, testValidBucket, prefix, ca, root); err != nil {
+		t.Fatalf("SaveSignedObject() error = %v", err)
+	}
+	cert, _ := newTestCertificate(t, protocol.HostCertificate, "test.example.com", []string{"test.example.com"}, exportedOn, time.Hour)
+	if err := cert.SaveObject(source, testValidBucket, prefix); err != nil {
+		t.Fatalf("SaveObject() error = %v", err)
+	}
+
+	var bundle bytes.Buffer
+	if err := protocol.ExportBundle(source, testValidBucket, prefix, &bundle, root, &protocol.ExportOptions{Now: exportedOn}); err != nil {
+		t.Fatalf("ExportBundle() error = %v", err)
+	}
+
+	target, err := fakeaws.NewDirS3(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = target.AddBucket(testValidBucket)
+	got, err := protocol.ImportBundle(target, testValidBucket, "imported/", bytes.NewReader(bundle.Bytes()), anchor, nil)
+	if err != nil {
+		t.Fatalf("ImportBundle() error = %v", err)
+	}
+	want := &protocol.ImportResult{Imported: []string{ca.ObjectKey("imported/"), cert.ObjectKey("imported/")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportBundle() got = %+v, want %+v", got, want)
+	}
+	// History and serial index objects aren't part of the default bundle
+	if keys := target.Keys(testValidBucket, "imported/"); len(keys) != 2 {
+		t.Errorf("ImportBundle() wrote %v, want only the exported objects", keys)
+	}
+
+	t.Run("signatures survive the trip", func(t *testing.T) {
+		// Signatures are bound to the full key, so the copy must land under the same prefix
+		sameKeys := fakeaws.NewS3(testValidBucket)
+		if _, err := protocol.ImportBundle(sameKeys, testValidBucket, prefix, bytes.NewReader(bundle.Bytes()), anchor, nil); err != nil {
+			t.Fatalf("ImportBundle() error = %v", err)
+		}
+		imported := &protocol.CAPublicKeyS3Object{}
+		if err := imported.LoadVerifiedObject(sameKeys, testValidBucket, ca.ObjectKey(prefix), anchor); err != nil {
+			t.Errorf("LoadVerifiedObject() error = %v", err)
+		}
+	})
+
+	t.Run("importing again skips identical objects", func(t *testing.T) {
+		got, err := protocol.ImportBundle(target, testValidBucket, "imported/", bytes.NewReader(bundle.Bytes()), anchor, nil)
+		if err != nil {
+			t.Fatalf("ImportBundle() error = %v", err)
+		}
+		if len(got.Imported) != 0 || len(got.Skipped) != 2 {
+			t.Errorf("ImportBundle() got = %+v, want everything skipped", got)
+		}
+	})
+
+	t.Run("objects written locally are not overwritten", func(t *testing.T) {
+		local := fakeaws.NewS3(testValidBucket)
+		local.Now = func() time.Time { return exportedOn.Add(-time.Hour) }
+		_ = local.Put(testValidBucket, cert.ObjectKey(prefix), []byte(`{"identity":"local"}`))
+		got, err := protocol.ImportBundle(local, testValidBucket, prefix, bytes.NewReader(bundle.Bytes()), anchor, nil)
+		if err != nil {
+			t.Fatalf("ImportBundle() error = %v", err)
+		}
+		want := &protocol.ImportResult{Imported: []string{ca.ObjectKey(prefix)}, Skipped: []string{cert.ObjectKey(prefix)}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ImportBundle() got = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("reissued objects replace earlier imports", func(t *testing.T) {
+		// target stamps LastModified with the real clock, well after anything in source
+		reissuedOn := exportedOn.Add(time.Hour)
+		source.Now = func() time.Time { return reissuedOn }
+		reissued := *cert
+		reissued.Serial, reissued.IssuedOn = 2, reissuedOn
+		if err := reissued.SaveObject(source, testValidBucket, prefix); err != nil {
+			t.Fatalf("SaveObject() error = %v", err)
+		}
+		var newer bytes.Buffer
+		if err := protocol.ExportBundle(source, testValidBucket, prefix, &newer, root, &protocol.ExportOptions{Now: reissuedOn}); err != nil {
+			t.Fatalf("ExportBundle() error = %v", err)
+		}
+		got, err := protocol.ImportBundle(target, testValidBucket, "imported/", bytes.NewReader(newer.Bytes()), anchor, nil)
+		if err != nil {
+			t.Fatalf("ImportBundle() error = %v", err)
+		}
+		want := &protocol.ImportResult{Imported: []string{cert.ObjectKey("imported/")}, Skipped: []string{ca.ObjectKey("imported/")}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ImportBundle() got = %+v, want %+v", got, want)
+		}
+		imported := &protocol.SignedCertificateS3Object{}
+		if err := imported.LoadObject(target, testValidBucket, cert.ObjectKey("imported/")); err != nil {
+			t.Fatalf("LoadObject() error = %v", err)
+		}
+		if !imported.IssuedOn.Equal(reissuedOn) {
+			t.Errorf("LoadObject() IssuedOn = %v, want %v", imported.IssuedOn, reissuedOn)
+		}
+
+		// The earlier bundle is now older than what was imported
+		got, err = protocol.ImportBundle(target, testValidBucket, "imported/", bytes.NewReader(bundle.Bytes()), anchor, nil)
+		if err != nil {
+			t.Fatalf("ImportBundle() error = %v", err)
+		}
+		if len(got.Imported) != 0 || len(got.Skipped) != 2 {
+			t.Errorf("ImportBundle() got = %+v, want everything skipped", got)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		bundle []byte
+		anchor protocol.TrustAnchor
+	}{
+		{name: "untrusted signer", bundle: bundle.Bytes(), anchor: protocol.NewTrustAnchor(newTestSigner(t).PublicKey())},
+		{name: "tampered object", bundle: rewriteBundle(t, bundle.Bytes(), "objects/"+protocol.S3CaPubkeyPrefix+"user.json", []byte(`{}`)), anchor: anchor},
+		{name: "tampered manifest", bundle: rewriteBundle(t, bundle.Bytes(), "manifest.json", []byte(`{"version":1}`)), anchor: anchor},
+		{name: "extra object", bundle: rewriteBundle(t, bundle.Bytes(), "objects/Signed-Certs/extra.json", []byte(`{}`)), anchor: anchor},
+		{name: "not a bundle", bundle: []byte("hello"), anchor: anchor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			empty := fakeaws.NewS3(testValidBucket)
+			if _, err := protocol.ImportBundle(empty, testValidBucket, prefix, bytes.NewReader(tt.bundle), tt.anchor, nil); !errors.Is(err, protocol.ErrBundleInvalid) {
+				t.Errorf("ImportBundle() error = %v, wantErr %v", err, protocol.ErrBundleInvalid)
+			}
+			if keys := empty.Keys(testValidBucket, ""); len(keys) != 0 {
+				t.Errorf("ImportBundle() wrote %v from an invalid bundle", keys)
+			}
+		})
+	}
+	t.Run("objects over MaxSize", func(t *testing.T) {
+		empty := fakeaws.NewS3(testValidBucket)
+		opts := &protocol.LoadOptions{MaxSize: 8}
+		if _, err := protocol.ImportBundle(empty, testValidBucket, prefix, bytes.NewReader(bundle.Bytes()), anchor, opts); !errors.Is(err, protocol.ErrBundleInvalid) {
+			t.Errorf("ImportBundle() error = %v, wantErr %v", err, protocol.ErrBundleInvalid)
+		}
+	})
+}
+
+// rewriteBundle replaces (or adds) the named file in a bundle without touching the signature
+func rewriteBundle(t *testing.T, bundle []byte, name string, body []byte) []byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var out bytes.Buffer
+	gzw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gzw)
+	write := func(name string, body []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replaced := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		if hdr.Name == name {
+			content, replaced = body, true
+		}
+		write(hdr.Name, content)
+	}
+	if !replaced {
+		write(name, body)
+	}
+	_ = tw.Close()
+	_ = gzw.Close()
+	return out.Bytes()
+}
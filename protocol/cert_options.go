@@ -0,0 +1,260 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"encoding/json"
+)
+
+// OpenSSH critical option names
+const (
+	OptionForceCommand   = "force-command"
+	OptionSourceAddress  = "source-address"
+	OptionVerifyRequired = "verify-required"
+)
+
+// OpenSSH extension names
+const (
+	ExtensionPermitPTY             = "permit-pty"
+	ExtensionPermitPortForwarding  = "permit-port-forwarding"
+	ExtensionPermitAgentForwarding = "permit-agent-forwarding"
+	ExtensionPermitX11Forwarding   = "permit-X11-forwarding"
+	ExtensionPermitUserRC          = "permit-user-rc"
+	ExtensionNoTouchRequired       = "no-touch-required"
+)
+
+// CriticalOptions are the OpenSSH critical options a user certificate can carry.
+// sshd refuses certificates with critical options it doesn't understand.
+type CriticalOptions struct {
+	// Command to run instead of the one requested by the user
+	ForceCommand string `json:"force_command,omitempty"`
+	// Addresses (CIDR or plain IPs) the certificate may be used from
+	SourceAddress []string `json:"source_address,omitempty"`
+	// Require user presence to be verified for FIDO security keys (PIN or biometrics)
+	VerifyRequired bool `json:"verify_required,omitempty"`
+}
+
+// Extensions are the OpenSSH extensions a user certificate can carry,
+// unknown extensions are ignored by sshd
+type Extensions struct {
+	PermitPTY             bool `json:"permit_pty,omitempty"`
+	PermitPortForwarding  bool `json:"permit_port_forwarding,omitempty"`
+	PermitAgentForwarding bool `json:"permit_agent_forwarding,omitempty"`
+	PermitX11Forwarding   bool `json:"permit_x11_forwarding,omitempty"`
+	PermitUserRC          bool `json:"permit_user_rc,omitempty"`
+	// Allow FIDO security keys to sign without a touch
+	NoTouchRequired bool `json:"no_touch_required,omitempty"`
+	// Vendor extensions, names must be in the "name@domain" format
+	Custom map[string]string `json:"custom,omitempty"`
+}
+
+// DefaultExtensions returns the extensions ssh-keygen grants user certificates when no options are given
+func DefaultExtensions() Extensions {
+	return Extensions{
+		PermitPTY:             true,
+		PermitPortForwarding:  true,
+		PermitAgentForwarding: true,
+		PermitX11Forwarding:   true,
+		PermitUserRC:          true,
+	}
+}
+
+// CertificateOptions holds the critical options and extensions requested for a certificate.
+//
+// On the wire this is encoded as a list of `ssh-keygen -O` style options, the same format
+// the old `UserKeyOptions []string` field used, so older payloads and stored objects decode
+// into the typed fields:
+//
+//	["clear", "permit-pty", "source-address=10.0.0.0/8", "extension:login@example.com=admin"]
+//
+// Decoding starts from DefaultExtensions, same as ssh-keygen. Like ssh-keygen, "clear" drops
+// everything before it, including critical options and custom extensions.
+// The object form `{"critical_options": {...}, "extensions": {...}}` is also accepted.
+type CertificateOptions struct {
+	CriticalOptions CriticalOptions `json:"critical_options"`
+	Extensions      Extensions      `json:"extensions"`
+}
+
+// ParseCertificateOptions parses a list of `ssh-keygen -O` style options
+//
+// Returns an error for unknown options or options missing a required value
+func ParseCertificateOptions(options []string) (*CertificateOptions, error) {
+	opts := &CertificateOptions{Extensions: DefaultExtensions()}
+	for _, option := range options {
+		name, value, hasValue := strings.Cut(option, "=")
+		permits := map[string]*bool{
+			"pty":              &opts.Extensions.PermitPTY,
+			"port-forwarding":  &opts.Extensions.PermitPortForwarding,
+			"agent-forwarding": &opts.Extensions.PermitAgentForwarding,
+			"x11-forwarding":   &opts.Extensions.PermitX11Forwarding,
+			"user-rc":          &opts.Extensions.PermitUserRC,
+		}
+		switch lower := strings.ToLower(name); {
+		case lower == "clear":
+			*opts = CertificateOptions{}
+		case lower == OptionForceCommand:
+			if !hasValue || value == "" {
+				return nil, fmt.Errorf("option '%s' requires a command", name)
+			}
+			opts.CriticalOptions.ForceCommand = value
+		case lower == OptionSourceAddress:
+			if !hasValue || value == "" {
+				return nil, fmt.Errorf("option '%s' requires an address list", name)
+			}
+			opts.CriticalOptions.SourceAddress = append(opts.CriticalOptions.SourceAddress, strings.Split(value, ",")...)
+		case lower == OptionVerifyRequired:
+			opts.CriticalOptions.VerifyRequired = true
+		case lower == ExtensionNoTouchRequired:
+			opts.Extensions.NoTouchRequired = true
+		case strings.HasPrefix(lower, "permit-") && permits[strings.TrimPrefix(lower, "permit-")] != nil:
+			*permits[strings.TrimPrefix(lower, "permit-")] = true
+		case strings.HasPrefix(lower, "no-") && permits[strings.TrimPrefix(lower, "no-")] != nil:
+			*permits[strings.TrimPrefix(lower, "no-")] = false
+		case strings.HasPrefix(name, "extension:"):
+			if opts.Extensions.Custom == nil {
+				opts.Extensions.Custom = map[string]string{}
+			}
+			opts.Extensions.Custom[strings.TrimPrefix(name, "extension:")] = value
+		default:
+			return nil, fmt.Errorf("unknown certificate option '%s'", option)
+		}
+	}
+	return opts, nil
+}
+
+// Strings returns the options as a list of `ssh-keygen -O` style options,
+// this is the inverse of ParseCertificateOptions
+func (o *CertificateOptions) Strings() []string {
+	options := []string{"clear"}
+	if o.CriticalOptions.ForceCommand != "" {
+		options = append(options, fmt.Sprintf("%s=%s", OptionForceCommand, o.CriticalOptions.ForceCommand))
+	}
+	if len(o.CriticalOptions.SourceAddress) > 0 {
+		options = append(options, fmt.Sprintf("%s=%s", OptionSourceAddress, strings.Join(o.CriticalOptions.SourceAddress, ",")))
+	}
+	if o.CriticalOptions.VerifyRequired {
+		options = append(options, OptionVerifyRequired)
+	}
+	for name, enabled := range o.standardExtensions() {
+		if enabled {
+			options = append(options, name)
+		}
+	}
+	for _, name := range sortedKeys(o.Extensions.Custom) {
+		if value := o.Extensions.Custom[name]; value != "" {
+			options = append(options, fmt.Sprintf("extension:%s=%s", name, value))
+		} else {
+			options = append(options, "extension:"+name)
+		}
+	}
+	sort.Strings(options[1:])
+	return options
+}
+
+// Validate checks the options are acceptable to sshd
+//
+// Returns an error if a source-address isn't a valid CIDR or IP
+// or a custom extension name isn't in the "name@domain" format
+func (o *CertificateOptions) Validate() error {
+	for _, addr := range o.CriticalOptions.SourceAddress {
+		if _, _, err := net.ParseCIDR(addr); err == nil {
+			continue
+		}
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid %s '%s': not a CIDR or IP address", OptionSourceAddress, addr)
+		}
+	}
+	for name := range o.Extensions.Custom {
+		if at := strings.Index(name, "@"); at < 1 || at == len(name)-1 {
+			return fmt.Errorf("invalid custom extension '%s': must be in the name@domain format", name)
+		}
+	}
+	return nil
+}
+
+// CriticalOptionsMap returns the critical options in the format used by ssh.Permissions
+func (o *CertificateOptions) CriticalOptionsMap() map[string]string {
+	options := map[string]string{}
+	if o.CriticalOptions.ForceCommand != "" {
+		options[OptionForceCommand] = o.CriticalOptions.ForceCommand
+	}
+	if len(o.CriticalOptions.SourceAddress) > 0 {
+		options[OptionSourceAddress] = strings.Join(o.CriticalOptions.SourceAddress, ",")
+	}
+	if o.CriticalOptions.VerifyRequired {
+		options[OptionVerifyRequired] = ""
+	}
+	return options
+}
+
+// ExtensionsMap returns the extensions in the format used by ssh.Permissions
+func (o *CertificateOptions) ExtensionsMap() map[string]string {
+	extensions := map[string]string{}
+	for name, enabled := range o.standardExtensions() {
+		if enabled {
+			extensions[name] = ""
+		}
+	}
+	for name, value := range o.Extensions.Custom {
+		extensions[name] = value
+	}
+	return extensions
+}
+
+// MarshalJSON encodes the options as a list of `ssh-keygen -O` style options, see Strings
+func (o *CertificateOptions) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.Strings())
+}
+
+// UnmarshalJSON decodes either a list of `ssh-keygen -O` style options or the object form
+//
+// Returns an error if the options cannot be parsed or fail validation
+func (o *CertificateOptions) UnmarshalJSON(data []byte) error {
+	var parsed *CertificateOptions
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		// Alias drops the methods so this doesn't recurse
+		type certificateOptions CertificateOptions
+		parsed = &CertificateOptions{}
+		if err := json.Unmarshal(trimmed, (*certificateOptions)(parsed)); err != nil {
+			return err
+		}
+	} else {
+		var options []string
+		if err := json.Unmarshal(data, &options); err != nil {
+			return err
+		}
+		var err error
+		if parsed, err = ParseCertificateOptions(options); err != nil {
+			return err
+		}
+	}
+	if err := parsed.Validate(); err != nil {
+		return err
+	}
+	*o = *parsed
+	return nil
+}
+
+func (o *CertificateOptions) standardExtensions() map[string]bool {
+	return map[string]bool{
+		ExtensionPermitPTY:             o.Extensions.PermitPTY,
+		ExtensionPermitPortForwarding:  o.Extensions.PermitPortForwarding,
+		ExtensionPermitAgentForwarding: o.Extensions.PermitAgentForwarding,
+		ExtensionPermitX11Forwarding:   o.Extensions.PermitX11Forwarding,
+		ExtensionPermitUserRC:          o.Extensions.PermitUserRC,
+		ExtensionNoTouchRequired:       o.Extensions.NoTouchRequired,
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
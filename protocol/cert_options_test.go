@@ -0,0 +1,198 @@
+package protocol_test
+
+import (
+	"reflect"
+	"testing"
+
+	"encoding/json"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestParseCertificateOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    *protocol.CertificateOptions
+		wantErr bool
+	}{
+		{
+			name:    "no options yields ssh-keygen defaults",
+			options: nil,
+			want:    &protocol.CertificateOptions{Extensions: protocol.DefaultExtensions()},
+		},
+		{
+			name:    "clear drops the default extensions",
+			options: []string{"clear", "permit-pty"},
+			want:    &protocol.CertificateOptions{Extensions: protocol.Extensions{PermitPTY: true}},
+		},
+		{
+			name:    "clear drops earlier critical options and custom extensions",
+			options: []string{"extension:foo@x", "force-command=ls", "clear"},
+			want:    &protocol.CertificateOptions{},
+		},
+		{
+			name: "critical options and no- extensions",
+			options: []string{
+				"force-command=/usr/bin/rsync --server",
+				"source-address=10.0.0.0/8,192.168.1.1",
+				"verify-required",
+				"no-agent-forwarding",
+				"no-X11-forwarding",
+				"no-touch-required",
+			},
+			want: &protocol.CertificateOptions{
+				CriticalOptions: protocol.CriticalOptions{
+					ForceCommand:   "/usr/bin/rsync --server",
+					SourceAddress:  []string{"10.0.0.0/8", "192.168.1.1"},
+					VerifyRequired: true,
+				},
+				Extensions: protocol.Extensions{
+					PermitPTY:            true,
+					PermitPortForwarding: true,
+					PermitUserRC:         true,
+					NoTouchRequired:      true,
+				},
+			},
+		},
+		{
+			name:    "custom extensions",
+			options: []string{"clear", "extension:login@example.com=admin", "extension:audit@example.com"},
+			want: &protocol.CertificateOptions{
+				Extensions: protocol.Extensions{
+					Custom: map[string]string{"login@example.com": "admin", "audit@example.com": ""},
+				},
+			},
+		},
+		{
+			name:    "unknown options are rejected",
+			options: []string{"permit-everything"},
+			wantErr: true,
+		},
+		{
+			name:    "force-command requires a command",
+			options: []string{"force-command"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := protocol.ParseCertificateOptions(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCertificateOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCertificateOptions() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    protocol.CertificateOptions
+		wantErr bool
+	}{
+		{
+			name: "valid addresses and extensions",
+			opts: protocol.CertificateOptions{
+				CriticalOptions: protocol.CriticalOptions{SourceAddress: []string{"10.0.0.0/8", "2001:db8::/32", "127.0.0.1"}},
+				Extensions:      protocol.Extensions{Custom: map[string]string{"login@example.com": "admin"}},
+			},
+		},
+		{
+			name:    "invalid source-address",
+			opts:    protocol.CertificateOptions{CriticalOptions: protocol.CriticalOptions{SourceAddress: []string{"10.0.0.0/33"}}},
+			wantErr: true,
+		},
+		{
+			name:    "custom extension without a domain",
+			opts:    protocol.CertificateOptions{Extensions: protocol.Extensions{Custom: map[string]string{"login@": ""}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertificateOptions_JSON(t *testing.T) {
+	opts := &protocol.CertificateOptions{
+		CriticalOptions: protocol.CriticalOptions{
+			ForceCommand:  "/bin/true",
+			SourceAddress: []string{"10.0.0.0/8"},
+		},
+		Extensions: protocol.Extensions{
+			PermitPTY: true,
+			Custom:    map[string]string{"login@example.com": "admin"},
+		},
+	}
+	wantJSON := `["clear","extension:login@example.com=admin","force-command=/bin/true","permit-pty","source-address=10.0.0.0/8"]`
+	got, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatalf("MarshalJSON() error = %v", err)
+	}
+	if string(got) != wantJSON {
+		t.Errorf("MarshalJSON() got = %s, want %s", got, wantJSON)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    *protocol.CertificateOptions
+		wantErr bool
+	}{
+		{name: "round trips the string list", data: wantJSON, want: opts},
+		{
+			name: "decodes the object form",
+			data: `{"critical_options":{"force_command":"/bin/true","source_address":["10.0.0.0/8"]},"extensions":{"permit_pty":true,"custom":{"login@example.com":"admin"}}}`,
+			want: opts,
+		},
+		{name: "rejects invalid source addresses", data: `["source-address=nope"]`, wantErr: true},
+		{name: "rejects other JSON types", data: `"permit-pty"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &protocol.CertificateOptions{}
+			if err := json.Unmarshal([]byte(tt.data), got); (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshalJSON() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestSSHCertLambdaPayload_LegacyUserKeyOptions(t *testing.T) {
+	payload := &protocol.RequestSSHCertLambdaPayload{}
+	err := json.Unmarshal([]byte(`{"certificate_type":"user","user_key_options":["no-pty","force-command=/bin/true"]}`), payload)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := protocol.DefaultExtensions()
+	want.PermitPTY = false
+	if !reflect.DeepEqual(payload.CertificateOptions.Extensions, want) || payload.CertificateOptions.CriticalOptions.ForceCommand != "/bin/true" {
+		t.Errorf("Unmarshal() got = %+v", payload.CertificateOptions)
+	}
+}
+
+func TestCertificateOptions_Maps(t *testing.T) {
+	opts := &protocol.CertificateOptions{
+		CriticalOptions: protocol.CriticalOptions{SourceAddress: []string{"10.0.0.0/8", "127.0.0.1"}, VerifyRequired: true},
+		Extensions:      protocol.Extensions{PermitPTY: true, Custom: map[string]string{"login@example.com": "admin"}},
+	}
+	wantCritical := map[string]string{"source-address": "10.0.0.0/8,127.0.0.1", "verify-required": ""}
+	if got := opts.CriticalOptionsMap(); !reflect.DeepEqual(got, wantCritical) {
+		t.Errorf("CriticalOptionsMap() got = %v, want %v", got, wantCritical)
+	}
+	wantExtensions := map[string]string{"permit-pty": "", "login@example.com": "admin"}
+	if got := opts.ExtensionsMap(); !reflect.DeepEqual(got, wantExtensions) {
+		t.Errorf("ExtensionsMap() got = %v, want %v", got, wantExtensions)
+	}
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrCAFingerprintMismatch is returned when a CA's AuthorizedKey doesn't hash to its recorded KeyFingerprint
+var ErrCAFingerprintMismatch = errors.New("CA fingerprint mismatch")
+
+// CertificateBundle is everything a host or user needs from Schism:
+// their own Certificate and the Public half of the opposite CA
+//
+// Hosts get the User CA to authenticate UserCertificates, users get the Host CA to authenticate hosts.
+// Not to be confused with the offline bundles written by ExportBundle
+type CertificateBundle struct {
+	Certificate *SignedCertificateS3Object
+	// The CA object the Certificate's OppositePublicCA points at
+	OppositeCA *CAPublicKeyS3Object
+
+	// The parsed Certificate.RawSignedCertificate
+	SSHCertificate *ssh.Certificate
+	// The parsed OppositeCA.AuthorizedKey, its fingerprint matches OppositeCA.KeyFingerprint if one is recorded
+	OppositeCAKey ssh.PublicKey
+}
+
+// LoadBundle loads the latest Certificate for the (possibly partial) LookupKey,
+// follows its OppositePublicCA to the CAPublicKeyS3Object and verifies the CA's fingerprint
+//
+// Returns a ProtocolError with ErrCodeNotFound if there is no CA at OppositePublicCA
+func LoadBundle(s3Svc s3iface.S3API, s3Bucket string, prefix string, lk *LookupKey) (*CertificateBundle, error) {
+	cert, err := LoadByLookupKey(s3Svc, s3Bucket, prefix, lk)
+	if err != nil {
+		return nil, err
+	}
+	return ResolveBundle(s3Svc, s3Bucket, cert)
+}
+
+// ResolveBundle is LoadBundle for an already loaded Certificate
+func ResolveBundle(s3Svc s3iface.S3API, s3Bucket string, cert *SignedCertificateS3Object) (*CertificateBundle, error) {
+	if cert.OppositePublicCA == "" {
+		return nil, fmt.Errorf("certificate %s has no opposite_public_ca", cert.LookupKey())
+	}
+	sshCert, err := cert.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	ca := &CAPublicKeyS3Object{}
+	if err = ca.LoadObject(s3Svc, s3Bucket, cert.OppositePublicCA); isNotFound(err) {
+		return nil, NewProtocolError(ErrCodeNotFound, "no CA found at %s", cert.OppositePublicCA)
+	} else if err != nil {
+		return nil, err
+	}
+	want, err := cert.CertificateType.OppositeCA()
+	if err != nil {
+		return nil, err
+	}
+	if ca.CertificateType != want {
+		return nil, fmt.Errorf("CA at %s is a %s CA, want a %s CA", cert.OppositePublicCA, ca.CertificateType, want)
+	}
+	caKey, err := ca.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("CA at %s: %w", cert.OppositePublicCA, err)
+	}
+	return &CertificateBundle{
+		Certificate:    cert,
+		OppositeCA:     ca,
+		SSHCertificate: sshCert,
+		OppositeCAKey:  caKey,
+	}, nil
+}
+
+// PublicKey parses AuthorizedKey and checks it against KeyFingerprint
+//
+// Legacy {host|user}.json objects saved without a KeyFingerprint have nothing to check against,
+// their key is returned as is
+func (c *CAPublicKeyS3Object) PublicKey() (ssh.PublicKey, error) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(c.AuthorizedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse CA key: %w", err)
+	}
+	if fingerprint := ssh.FingerprintSHA256(pubKey); c.KeyFingerprint != "" && fingerprint != c.KeyFingerprint {
+		return nil, fmt.Errorf("%w: key is %s, recorded as '%s'", ErrCAFingerprintMismatch, fingerprint, c.KeyFingerprint)
+	}
+	return pubKey, nil
+}
+
+// CertificateLine returns the Certificate in authorized_keys format, as written to `-cert.pub` files
+func (b *CertificateBundle) CertificateLine() []byte {
+	return ssh.MarshalAuthorizedKey(b.SSHCertificate)
+}
+
+// CALine returns the opposite CA in authorized_keys format, ready for TrustedUserCAKeys on hosts.
+// For a known_hosts entry users must prefix it with `@cert-authority {host patterns} `
+func (b *CertificateBundle) CALine() []byte {
+	return ssh.MarshalAuthorizedKey(b.OppositeCAKey)
+}
+
+// Marshal returns CertificateLine followed by CALine
+func (b *CertificateBundle) Marshal() []byte {
+	return bytes.Join([][]byte{b.CertificateLine(), b.CALine()}, nil)
+}
@@ -0,0 +1,94 @@
+package protocol_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"encoding/json"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestLoadBundle(t *testing.T) {
+	const prefix = "test/"
+	userCA, otherCA := newTestSigner(t), newTestSigner(t)
+	caObject := func(certType protocol.CertType, key, fingerprintOf ssh.PublicKey) *protocol.CAPublicKeyS3Object {
+		ca := &protocol.CAPublicKeyS3Object{
+			CertificateType: certType,
+			AuthorizedKey:   ssh.MarshalAuthorizedKey(key),
+		}
+		if fingerprintOf != nil {
+			ca.KeyFingerprint = ssh.FingerprintSHA256(fingerprintOf)
+		}
+		return ca
+	}
+	tests := []struct {
+		name     string
+		ca       *protocol.CAPublicKeyS3Object
+		caKey    string
+		wantErr  bool
+		wantIs   error
+		wantCode protocol.ErrorCode
+	}{
+		{name: "valid", ca: caObject(protocol.UserCertificate, userCA.PublicKey(), userCA.PublicKey())},
+		{name: "missing CA", caKey: prefix + protocol.S3CaPubkeyPrefix + "missing.json", wantErr: true, wantCode: protocol.ErrCodeNotFound},
+		{name: "no opposite CA", wantErr: true},
+		{name: "legacy CA without fingerprint", ca: caObject(protocol.UserCertificate, userCA.PublicKey(), nil)},
+		{name: "fingerprint mismatch", ca: caObject(protocol.UserCertificate, userCA.PublicKey(), otherCA.PublicKey()), wantErr: true, wantIs: protocol.ErrCAFingerprintMismatch},
+		{name: "same side CA", ca: caObject(protocol.HostCertificate, userCA.PublicKey(), userCA.PublicKey()), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3Svc := fakeaws.NewS3(testValidBucket)
+			cert, _ := newTestCertificate(t, protocol.HostCertificate, "test.example.com", []string{"test.example.com"}, time.Now(), time.Hour)
+			if tt.ca != nil {
+				body, _ := json.Marshal(tt.ca)
+				if err := s3Svc.Put(testValidBucket, tt.ca.ObjectKey(prefix), body); err != nil {
+					t.Fatal(err)
+				}
+				cert.OppositePublicCA = tt.ca.ObjectKey(prefix)
+			} else {
+				cert.OppositePublicCA = tt.caKey
+			}
+			if err := cert.SaveObject(s3Svc, testValidBucket, prefix); err != nil {
+				t.Fatal(err)
+			}
+			lk := cert.LookupKey()
+			lk.Id = lk.Id[:8]
+
+			got, err := protocol.LoadBundle(s3Svc, testValidBucket, prefix, lk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+				t.Errorf("LoadBundle() error = %v, want %v", err, tt.wantIs)
+			}
+			if tt.wantCode != "" {
+				if perr := protocol.AsProtocolError(err); perr.Code != tt.wantCode {
+					t.Errorf("LoadBundle() error = %v, want code %v", err, tt.wantCode)
+				}
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(got.OppositeCAKey.Marshal(), userCA.PublicKey().Marshal()) {
+				t.Errorf("LoadBundle() OppositeCAKey = %s, want %s", ssh.FingerprintSHA256(got.OppositeCAKey), ssh.FingerprintSHA256(userCA.PublicKey()))
+			}
+			want := append(bytes.TrimSpace(cert.RawSignedCertificate), '\n')
+			want = append(want, ssh.MarshalAuthorizedKey(userCA.PublicKey())...)
+			if !bytes.Equal(got.Marshal(), want) {
+				t.Errorf("Marshal() = %s, want %s", got.Marshal(), want)
+			}
+		})
+	}
+
+	_, err := protocol.LoadBundle(fakeaws.NewS3(testValidBucket), testValidBucket, prefix, &protocol.LookupKey{Id: "deadbeef", Type: protocol.HostCertificate})
+	if err == nil {
+		t.Errorf("LoadBundle() of a missing certificate error = nil, want error")
+	}
+}
@@ -0,0 +1,309 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"encoding/json"
+)
+
+// SchemaVersion identifies how payloads and stored objects are encoded
+//
+// Readers accept every version, writers stay on SchemaV1 until every reader understands newer versions
+type SchemaVersion string
+
+// Valid options for SchemaVersion
+const (
+	// validity_interval is encoded as integer nanoseconds
+	SchemaV1 SchemaVersion = ""
+	// validity_interval is encoded as a readable Duration string, see FormatDuration
+	SchemaV2 SchemaVersion = "v2"
+)
+
+// Errors returned (wrapped) when decoding durations and versioned payloads
+var (
+	ErrInvalidDuration          = errors.New("invalid duration")
+	ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+)
+
+// check returns an error wrapping ErrUnsupportedSchemaVersion for anything but SchemaV1 and SchemaV2
+func (v SchemaVersion) check() error {
+	if v != SchemaV1 && v != SchemaV2 {
+		return fmt.Errorf("%w: '%s'", ErrUnsupportedSchemaVersion, v)
+	}
+	return nil
+}
+
+// Duration is a time.Duration with a human friendly JSON encoding
+//
+// Decoding accepts integer nanoseconds (the time.Duration encoding) or a string, see ParseDuration.
+// Encoding always writes the string form, see FormatDuration
+type Duration time.Duration
+
+var (
+	dayDurationPattern = regexp.MustCompile(`^([-+]?)(\d+)d(.*)$`)
+	isoDurationPattern = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+)
+
+// ParseDuration parses a time.ParseDuration string that may start with a number of days,
+// or an ISO-8601 duration made of weeks, days, hours, minutes and seconds
+//
+//  Example:
+//   "12h", "90m", "5d", "1d12h", "-1d12h", "P5D", "PT12H", "P1DT12H30M"
+//
+// A sign in front of days applies to the whole duration, "-1d12h" is -36h,
+// ISO-8601 years and months are rejected since their length varies
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	var (
+		d   time.Duration
+		err error
+	)
+	switch {
+	case strings.HasPrefix(s, "P"):
+		d, err = parseISODuration(s)
+	case dayDurationPattern.MatchString(s):
+		match := dayDurationPattern.FindStringSubmatch(s)
+		var rest time.Duration
+		if match[3] != "" {
+			if rest, err = time.ParseDuration(match[3]); err != nil {
+				break
+			}
+		}
+		d, err = addDuration(0, match[2], 24*time.Hour)
+		if err == nil && rest < 0 {
+			err = errors.New("days cannot be followed by a negative duration, put the sign in front")
+		}
+		if d += rest; match[1] == "-" {
+			d = -d
+		}
+	default:
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w '%s': %v", ErrInvalidDuration, s, err)
+	}
+	return d, nil
+}
+
+func parseISODuration(s string) (time.Duration, error) {
+	match := isoDurationPattern.FindStringSubmatch(s)
+	if match == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, errors.New("not an ISO-8601 duration of weeks, days, hours, minutes and seconds")
+	}
+	var (
+		d   time.Duration
+		err error
+	)
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute} {
+		if d, err = addDuration(d, match[i+1], unit); err != nil {
+			return 0, err
+		}
+	}
+	if match[5] != "" {
+		seconds, err := time.ParseDuration(match[5] + "s")
+		if err != nil {
+			return 0, err
+		}
+		d += seconds
+	}
+	return d, nil
+}
+
+// addDuration adds count units to d, failing instead of overflowing
+func addDuration(d time.Duration, count string, unit time.Duration) (time.Duration, error) {
+	if count == "" {
+		return d, nil
+	}
+	n, err := strconv.ParseInt(count, 10, 64)
+	if err != nil || n > int64((1<<63-1-d)/unit) {
+		return 0, errors.New("duration is too long")
+	}
+	return d + time.Duration(n)*unit, nil
+}
+
+// FormatDuration writes d as days, hours, minutes and seconds, e.g. "5d", "1d12h" or "90s"
+//
+// Durations with fractional seconds fall back to time.Duration.String, every output is accepted by ParseDuration
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0s"
+	case d%time.Second != 0:
+		return d.String()
+	case d < 0:
+		return "-" + FormatDuration(-d)
+	}
+	var out strings.Builder
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}} {
+		if n := d / unit.size; n > 0 {
+			out.WriteString(strconv.FormatInt(int64(n), 10) + unit.suffix)
+			d -= n * unit.size
+		}
+	}
+	return out.String()
+}
+
+// String returns FormatDuration(d)
+func (d Duration) String() string {
+	return FormatDuration(time.Duration(d))
+}
+
+// MarshalJSON encodes the Duration as a FormatDuration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes integer nanoseconds or a ParseDuration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+	var nanos int64
+	if err := json.Unmarshal(data, &nanos); err != nil {
+		return fmt.Errorf("%w: %s is neither a string nor integer nanoseconds", ErrInvalidDuration, data)
+	}
+	*d = Duration(nanos)
+	return nil
+}
+
+// The types below keep their time.Duration fields for Go callers and switch
+// validity_interval to Duration on the wire, see SchemaVersion
+
+// MarshalJSON encodes ValidityInterval according to SchemaVersion after Validate,
+// unknown SchemaVersions are an error wrapping ErrUnsupportedSchemaVersion
+func (p RequestSSHCertLambdaPayload) MarshalJSON() ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	type payload RequestSSHCertLambdaPayload
+	switch p.SchemaVersion {
+	case SchemaV1:
+		return json.Marshal(payload(p))
+	case SchemaV2:
+		return json.Marshal(struct {
+			payload
+			ValidityInterval Duration `json:"validity_interval"`
+		}{payload(p), Duration(p.ValidityInterval)})
+	default:
+		return nil, p.SchemaVersion.check()
+	}
+}
+
+// UnmarshalJSON accepts any ValidityInterval encoding Duration does, regardless of SchemaVersion,
+// rejects unknown SchemaVersions and runs Validate on the result
+func (p *RequestSSHCertLambdaPayload) UnmarshalJSON(data []byte) error {
+	type payload RequestSSHCertLambdaPayload
+	aux := struct {
+		*payload
+		ValidityInterval Duration `json:"validity_interval"`
+	}{(*payload)(p), Duration(p.ValidityInterval)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if err := p.SchemaVersion.check(); err != nil {
+		return err
+	}
+	p.ValidityInterval = time.Duration(aux.ValidityInterval)
+	return p.Validate()
+}
+
+// MarshalJSON encodes ValidityInterval according to SchemaVersion,
+// unknown SchemaVersions are an error wrapping ErrUnsupportedSchemaVersion
+func (p RenewSSHCertLambdaPayload) MarshalJSON() ([]byte, error) {
+	type payload RenewSSHCertLambdaPayload
+	switch p.SchemaVersion {
+	case SchemaV1:
+		return json.Marshal(payload(p))
+	case SchemaV2:
+		return json.Marshal(struct {
+			payload
+			ValidityInterval *Duration `json:"validity_interval,omitempty"`
+		}{payload(p), optionalDuration(p.ValidityInterval)})
+	default:
+		return nil, p.SchemaVersion.check()
+	}
+}
+
+// UnmarshalJSON accepts any ValidityInterval encoding Duration does, regardless of SchemaVersion,
+// but rejects unknown SchemaVersions
+func (p *RenewSSHCertLambdaPayload) UnmarshalJSON(data []byte) error {
+	type payload RenewSSHCertLambdaPayload
+	aux := struct {
+		*payload
+		ValidityInterval Duration `json:"validity_interval"`
+	}{(*payload)(p), Duration(p.ValidityInterval)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if err := p.SchemaVersion.check(); err != nil {
+		return err
+	}
+	p.ValidityInterval = time.Duration(aux.ValidityInterval)
+	return nil
+}
+
+// MarshalJSON encodes ValidityInterval according to SchemaVersion after Validate,
+// unknown SchemaVersions are an error wrapping ErrUnsupportedSchemaVersion
+func (c SignedCertificateS3Object) MarshalJSON() ([]byte, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	type object SignedCertificateS3Object
+	switch c.SchemaVersion {
+	case SchemaV1:
+		return json.Marshal(object(c))
+	case SchemaV2:
+		return json.Marshal(struct {
+			object
+			ValidityInterval Duration `json:"validity_interval"`
+		}{object(c), Duration(c.ValidityInterval)})
+	default:
+		return nil, c.SchemaVersion.check()
+	}
+}
+
+// UnmarshalJSON accepts any ValidityInterval encoding Duration does, regardless of SchemaVersion,
+// rejects unknown SchemaVersions and runs Validate on the result
+func (c *SignedCertificateS3Object) UnmarshalJSON(data []byte) error {
+	type object SignedCertificateS3Object
+	aux := struct {
+		*object
+		ValidityInterval Duration `json:"validity_interval"`
+	}{(*object)(c), Duration(c.ValidityInterval)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if err := c.SchemaVersion.check(); err != nil {
+		return err
+	}
+	c.ValidityInterval = time.Duration(aux.ValidityInterval)
+	return c.Validate()
+}
+
+// optionalDuration keeps omitempty working for zero durations
+func optionalDuration(d time.Duration) *Duration {
+	if d == 0 {
+		return nil
+	}
+	return (*Duration)(&d)
+}
@@ -0,0 +1,189 @@
+package protocol_test
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"encoding/json"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "hours", s: "12h", want: 12 * time.Hour},
+		{name: "go duration", s: "1h30m15s", want: time.Hour + 30*time.Minute + 15*time.Second},
+		{name: "days", s: "5d", want: 5 * 24 * time.Hour},
+		{name: "days and hours", s: "1d12h", want: 36 * time.Hour},
+		{name: "iso days", s: "P5D", want: 5 * 24 * time.Hour},
+		{name: "iso time", s: "PT12H", want: 12 * time.Hour},
+		{name: "iso mixed", s: "P1W1DT2H30M1.5S", want: 8*24*time.Hour + 2*time.Hour + 30*time.Minute + 1500*time.Millisecond},
+		{name: "zero", s: "0", want: 0},
+		{name: "iso months", s: "P1M", wantErr: true},
+		{name: "iso years", s: "P1Y", wantErr: true},
+		{name: "iso empty", s: "P", wantErr: true},
+		{name: "iso empty time", s: "P1DT", wantErr: true},
+		{name: "bare integer", s: "3600", wantErr: true},
+		{name: "negative days", s: "-1d", want: -24 * time.Hour},
+		{name: "negative days and hours", s: "-1d12h", want: -36 * time.Hour},
+		{name: "positive sign", s: "+1d", want: 24 * time.Hour},
+		{name: "negative after days", s: "1d-1h", wantErr: true},
+		{name: "negative after negative days", s: "-1d-1h", wantErr: true},
+		{name: "overflow", s: "200000000d", wantErr: true},
+		{name: "garbage", s: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := protocol.ParseDuration(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, protocol.ErrInvalidDuration) {
+				t.Errorf("ParseDuration() error = %v, want %v", err, protocol.ErrInvalidDuration)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 0, want: "0s"},
+		{d: 5 * 24 * time.Hour, want: "5d"},
+		{d: 36*time.Hour + 30*time.Minute, want: "1d12h30m"},
+		{d: 90 * time.Second, want: "1m30s"},
+		{d: -12 * time.Hour, want: "-12h"},
+		{d: -24 * time.Hour, want: "-1d"},
+		{d: -(36*time.Hour + 30*time.Minute), want: "-1d12h30m"},
+		{d: 2*24*time.Hour + 5*time.Second, want: "2d5s"},
+		{d: -1500 * time.Millisecond, want: "-1.5s"},
+		{d: math.MinInt64, want: time.Duration(math.MinInt64).String()},
+		{d: 1500 * time.Millisecond, want: "1.5s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got := protocol.FormatDuration(tt.d)
+			if got != tt.want {
+				t.Errorf("FormatDuration() = %v, want %v", got, tt.want)
+			}
+			if back, err := protocol.ParseDuration(got); err != nil || back != tt.d {
+				t.Errorf("ParseDuration(FormatDuration()) = %v, %v, want %v", back, err, tt.d)
+			}
+		})
+	}
+}
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    protocol.Duration
+		wantErr bool
+	}{
+		{name: "legacy nanoseconds", data: "432000000000000", want: protocol.Duration(5 * 24 * time.Hour)},
+		{name: "string", data: `"5d"`, want: protocol.Duration(5 * 24 * time.Hour)},
+		{name: "iso string", data: `"PT30M"`, want: protocol.Duration(30 * time.Minute)},
+		{name: "null", data: "null"},
+		{name: "float", data: "1.5", wantErr: true},
+		{name: "bad string", data: `"5 days"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got protocol.Duration
+			if err := json.Unmarshal([]byte(tt.data), &got); (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("UnmarshalJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidityInterval_SchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version protocol.SchemaVersion
+		want    string
+	}{
+		{name: "v1 writes nanoseconds", version: protocol.SchemaV1, want: `"validity_interval":129600000000000`},
+		{name: "v2 writes a duration string", version: protocol.SchemaV2, want: `"validity_interval":"1d12h"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, v := range []interface{ MarshalJSON() ([]byte, error) }{
+				protocol.RequestSSHCertLambdaPayload{CertificateType: protocol.UserCertificate, ValidityInterval: 36 * time.Hour, SchemaVersion: tt.version},
+				protocol.RenewSSHCertLambdaPayload{ValidityInterval: 36 * time.Hour, SchemaVersion: tt.version},
+				protocol.SignedCertificateS3Object{CertificateType: protocol.UserCertificate, ValidityInterval: 36 * time.Hour, SchemaVersion: tt.version},
+			} {
+				body, err := json.Marshal(v)
+				if err != nil {
+					t.Fatalf("Marshal(%T) error = %v", v, err)
+				}
+				if !strings.Contains(string(body), tt.want) {
+					t.Errorf("Marshal(%T) = %s, want %s", v, body, tt.want)
+				}
+				renew := &protocol.RenewSSHCertLambdaPayload{}
+				if err := json.Unmarshal(body, renew); err != nil || renew.ValidityInterval != 36*time.Hour {
+					t.Errorf("Unmarshal() ValidityInterval = %v, error = %v, want %v", renew.ValidityInterval, err, 36*time.Hour)
+				}
+				if _, isRenew := v.(protocol.RenewSSHCertLambdaPayload); isRenew {
+					// renewals have no certificate_type, which the others require
+					continue
+				}
+				payload, cert := &protocol.RequestSSHCertLambdaPayload{}, &protocol.SignedCertificateS3Object{}
+				if err := json.Unmarshal(body, payload); err != nil || payload.ValidityInterval != 36*time.Hour {
+					t.Errorf("Unmarshal() ValidityInterval = %v, error = %v, want %v", payload.ValidityInterval, err, 36*time.Hour)
+				}
+				if err := json.Unmarshal(body, cert); err != nil || cert.ValidityInterval != 36*time.Hour {
+					t.Errorf("Unmarshal() ValidityInterval = %v, error = %v, want %v", cert.ValidityInterval, err, 36*time.Hour)
+				}
+			}
+		})
+	}
+
+	renew := &protocol.RenewSSHCertLambdaPayload{LookupKey: "user:v2:abc", SchemaVersion: protocol.SchemaV2}
+	if body, _ := json.Marshal(renew); strings.Contains(string(body), "validity_interval") {
+		t.Errorf("Marshal() = %s, want validity_interval omitted", body)
+	}
+
+	cert := &protocol.SignedCertificateS3Object{}
+	if err := json.Unmarshal([]byte(helperLoadString(t, "valid_signed_cert_s3_body.json")), cert); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cert.ValidityInterval != 5*24*time.Hour || cert.Identity != "test.example.com" {
+		t.Errorf("Unmarshal() = %+v, want a 5d test.example.com certificate", cert)
+	}
+
+	t.Run("unknown versions are rejected", func(t *testing.T) {
+		const v9 = protocol.SchemaVersion("v9")
+		body := []byte(`{"certificate_type":"user","lookup_key":"user:v2:abc","schema_version":"v9"}`)
+		for _, v := range []interface{}{&protocol.RequestSSHCertLambdaPayload{}, &protocol.RenewSSHCertLambdaPayload{}, &protocol.SignedCertificateS3Object{}} {
+			if err := json.Unmarshal(body, v); !errors.Is(err, protocol.ErrUnsupportedSchemaVersion) {
+				t.Errorf("Unmarshal(%T) error = %v, want %v", v, err, protocol.ErrUnsupportedSchemaVersion)
+			}
+		}
+		for _, v := range []interface{}{
+			protocol.RequestSSHCertLambdaPayload{CertificateType: protocol.UserCertificate, SchemaVersion: v9},
+			protocol.RenewSSHCertLambdaPayload{SchemaVersion: v9},
+			protocol.SignedCertificateS3Object{CertificateType: protocol.UserCertificate, SchemaVersion: v9},
+		} {
+			if _, err := json.Marshal(v); !errors.Is(err, protocol.ErrUnsupportedSchemaVersion) {
+				t.Errorf("Marshal(%T) error = %v, want %v", v, err, protocol.ErrUnsupportedSchemaVersion)
+			}
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode classifies a ProtocolError so callers can react without parsing messages
+type ErrorCode string
+
+// Known ErrorCodes
+const (
+	// The request was malformed or failed validation
+	ErrCodeInvalidRequest ErrorCode = "InvalidRequest"
+	// The submitted public key was rejected by the CA's KeyPolicy
+	ErrCodeKeyRejected ErrorCode = "KeyRejected"
+	// The caller isn't allowed to request the certificate
+	ErrCodeAccessDenied ErrorCode = "AccessDenied"
+	// The referenced certificate or object doesn't exist
+	ErrCodeNotFound ErrorCode = "NotFound"
+	// Anything else, usually a failure talking to AWS
+	ErrCodeInternal ErrorCode = "Internal"
+)
+
+// internalErrorMessage replaces the message of errors AsProtocolError classifies as ErrCodeInternal
+const internalErrorMessage = "internal error"
+
+// ProtocolError is the structured error returned to clients in Lambda responses
+type ProtocolError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+
+	// The error AsProtocolError converted, kept for the server and never sent to clients
+	cause error
+}
+
+// NewProtocolError returns a ProtocolError with a formatted message
+func NewProtocolError(code ErrorCode, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// HTTPStatus returns the HTTP status code for the error's Code, unknown codes are a 500
+func (e *ProtocolError) HTTPStatus() int {
+	switch e.Code {
+	case ErrCodeInvalidRequest:
+		return http.StatusBadRequest
+	case ErrCodeKeyRejected:
+		return http.StatusUnprocessableEntity
+	case ErrCodeAccessDenied:
+		return http.StatusForbidden
+	case ErrCodeNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error returns the error in the format "{Code}: {Message}"
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the error AsProtocolError converted, if any
+func (e *ProtocolError) Unwrap() error {
+	return e.cause
+}
+
+// AsProtocolError converts any error into a ProtocolError
+//
+// ProtocolErrors anywhere in the chain are returned as is, errors wrapping ErrKeyRejected
+// become ErrCodeKeyRejected and everything else is ErrCodeInternal.
+// Internal errors get a generic Message, as S3 errors name buckets and keys,
+// the original error is only available to the server through Unwrap.
+// Returns nil for a nil error
+func AsProtocolError(err error) *ProtocolError {
+	if err == nil {
+		return nil
+	}
+	var pErr *ProtocolError
+	switch {
+	case errors.As(err, &pErr):
+		return pErr
+	case errors.Is(err, ErrKeyRejected):
+		return &ProtocolError{Code: ErrCodeKeyRejected, Message: err.Error()}
+	default:
+		return &ProtocolError{Code: ErrCodeInternal, Message: internalErrorMessage, cause: err}
+	}
+}
@@ -0,0 +1,67 @@
+package protocol_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"encoding/json"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestAsProtocolError(t *testing.T) {
+	notFound := protocol.NewProtocolError(protocol.ErrCodeNotFound, "no certificate for %s", "host:55e8")
+	tests := []struct {
+		name string
+		err  error
+		want *protocol.ProtocolError
+	}{
+		{name: "nil stays nil", err: nil, want: nil},
+		{name: "protocol errors are returned as is", err: fmt.Errorf("wrapped: %w", notFound), want: notFound},
+		{
+			name: "rejected keys",
+			err:  fmt.Errorf("%w: DSA keys are not supported", protocol.ErrKeyRejected),
+			want: &protocol.ProtocolError{Code: protocol.ErrCodeKeyRejected, Message: "public key rejected: DSA keys are not supported"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := protocol.AsProtocolError(tt.err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AsProtocolError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("everything else is internal without details", func(t *testing.T) {
+		cause := errors.New("NoSuchBucket: schism-prod/Signed-Certs/host:55e8.json")
+		got := protocol.AsProtocolError(fmt.Errorf("unable to load certificate: %w", cause))
+		if got.Code != protocol.ErrCodeInternal || strings.Contains(got.Message, "schism-prod") {
+			t.Errorf("AsProtocolError() = %v, want a generic %v", got, protocol.ErrCodeInternal)
+		}
+		if body, _ := json.Marshal(got); strings.Contains(string(body), "schism-prod") {
+			t.Errorf("Marshal() = %s, want no details", body)
+		}
+		if !errors.Is(got, cause) {
+			t.Errorf("AsProtocolError() does not unwrap to %v", cause)
+		}
+	})
+}
+
+func TestProtocolError_HTTPStatus(t *testing.T) {
+	for code, want := range map[protocol.ErrorCode]int{
+		protocol.ErrCodeInvalidRequest: http.StatusBadRequest,
+		protocol.ErrCodeKeyRejected:    http.StatusUnprocessableEntity,
+		protocol.ErrCodeAccessDenied:   http.StatusForbidden,
+		protocol.ErrCodeNotFound:       http.StatusNotFound,
+		protocol.ErrCodeInternal:       http.StatusInternalServerError,
+		"SomethingNew":                 http.StatusInternalServerError,
+	} {
+		if got := protocol.NewProtocolError(code, "").HTTPStatus(); got != want {
+			t.Errorf("HTTPStatus(%s) = %v, want %v", code, got, want)
+		}
+	}
+}
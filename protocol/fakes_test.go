@@ -0,0 +1,97 @@
+package protocol_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crypto/ed25519"
+	"crypto/rand"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+const testValidBucket = "schism-test"
+
+// newTestS3 returns a fake S3 service seeded with the objects the protocol tests expect
+func newTestS3(t *testing.T) *fakeaws.S3 {
+	t.Helper()
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	for key, body := range map[string]string{
+		protocol.S3CaPubkeyPrefix + "user.json": helperLoadString(t, "valid_ca_pubkey_s3_body.json"),
+		protocol.S3CertStoragePrefix + "host:55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d.json":                  helperLoadString(t, "valid_signed_cert_s3_body.json"),
+		protocol.S3CertStoragePrefix + "user:4e1586bed08190ccac4056078afed44daac058e8361b216dd078c7714b874cae.json":                  "{}",
+		protocol.S3CertStoragePrefix + "user:4d5b5d59343254c4fccafe48813ceeb99ae5ce44c1b97113b370a93f8411a01e.json":                  "{}",
+		protocol.S3CertStoragePrefix + "host:d0c671a71f190313/d0c671a71f190313333bb79ed1a98fe7414da1089b3740de4ad5056c215512e7.json": "{}",
+		protocol.S3CertStoragePrefix + "user:v2:5b0936d4e0953418e95b79674ffc628d47a5ff6c7fc898d070a224da20dd99e7.json":               "{}",
+		"empty-objects": "",
+	} {
+		if err := s3Svc.Put(testValidBucket, key, []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s3Svc
+}
+
+func helperLoadString(t *testing.T, name string) string {
+	t.Helper()
+	bytes, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes)
+}
+
+// newTestSigner returns a freshly generated ed25519 ssh.Signer
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// newTestCertificate signs a new key with a throwaway CA and returns the stored object
+// along with the signer for the certified key
+func newTestCertificate(t *testing.T, certType protocol.CertType, ident string, principals []string, issuedOn time.Time, validity time.Duration) (*protocol.SignedCertificateS3Object, ssh.Signer) {
+	t.Helper()
+	ca, key := newTestSigner(t), newTestSigner(t)
+	sshCertType, err := certType.SSHCertType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		Serial:          1,
+		CertType:        sshCertType,
+		KeyId:           ident,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(issuedOn.Unix()),
+		ValidBefore:     uint64(issuedOn.Add(validity).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return &protocol.SignedCertificateS3Object{
+		CertificateType:      certType,
+		Serial:               cert.Serial,
+		IssuedOn:             issuedOn,
+		Identity:             ident,
+		Principals:           principals,
+		ValidityInterval:     validity,
+		ValidAfter:           issuedOn,
+		ValidBefore:          issuedOn.Add(validity),
+		RawSignedCertificate: ssh.MarshalAuthorizedKey(cert),
+		PublicKeyFingerprint: ssh.FingerprintSHA256(key.PublicKey()),
+		LookupKeyVersion:     protocol.LookupKeyV2,
+	}, key
+}
@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
+)
+
+// S3CertHistoryPrefix The subprefix for archiving every issuance of a Signed Certificate
+//   Full Object path will follow this template
+//    {profile.S3Prefix}{S3CertHistoryPrefix}{LookupKey}/{IssuedOn}.json
+//
+// The latest issuance is still saved under S3CertStoragePrefix,
+// so that key stays a stable pointer to the current Certificate
+const S3CertHistoryPrefix = "Signed-Certs-History/"
+
+// historyTimeFormat sorts lexically in issuance order and is safe to use in object keys
+const historyTimeFormat = "20060102T150405.000000000Z"
+
+// CertificateIssuance describes a single archived issuance of a Signed Certificate
+type CertificateIssuance struct {
+	// The S3 ObjectKey of the archived SignedCertificateS3Object
+	ObjectKey string
+	// When the Certificate was minted, parsed from the ObjectKey
+	IssuedOn time.Time
+}
+
+// HistoryObjectKey given a prefix, return the key this issuance is archived under
+//
+//  Format:
+//   {prefix}{S3CertHistoryPrefix}{LookupKey.String()}/{IssuedOn}.json
+func (c *SignedCertificateS3Object) HistoryObjectKey(prefix string) string {
+	return fmt.Sprintf("%s%s.json", historyPrefix(prefix, c.LookupKey()), c.IssuedOn.UTC().Format(historyTimeFormat))
+}
+
+// SaveObject archives the Certificate under HistoryObjectKey, records its Serial (if any)
+// in the serial index, then saves it under ObjectKey, replacing the previous latest issuance
+//
+// IssuedOn should be set before saving, issuances with the same IssuedOn overwrite each other
+func (c *SignedCertificateS3Object) SaveObject(s3Svc s3iface.S3API, s3Bucket string, prefix string) error {
+	return c.saveObject(s3Svc, s3Bucket, prefix, nil)
+}
+
+// SaveSignedObject is SaveObject but every object written is signed by signer, see LoadVerifiedObject
+func (c *SignedCertificateS3Object) SaveSignedObject(s3Svc s3iface.S3API, s3Bucket string, prefix string, signer ssh.Signer) error {
+	return c.saveObject(s3Svc, s3Bucket, prefix, signer)
+}
+
+func (c *SignedCertificateS3Object) saveObject(s3Svc s3iface.S3API, s3Bucket string, prefix string, signer ssh.Signer) error {
+	if err := rawSaveSignedS3Object(s3Svc, s3Bucket, c.HistoryObjectKey(prefix), c, signer); err != nil {
+		return err
+	}
+	if c.Serial != 0 {
+		index := &SerialIndexS3Object{
+			Serial:           c.Serial,
+			CertificateType:  c.CertificateType,
+			LookupKey:        c.LookupKey().String(),
+			HistoryObjectKey: c.HistoryObjectKey(prefix),
+		}
+		if err := rawSaveSignedS3Object(s3Svc, s3Bucket, index.ObjectKey(prefix), index, signer); err != nil {
+			return err
+		}
+	}
+	return rawSaveSignedS3Object(s3Svc, s3Bucket, c.ObjectKey(prefix), c, signer)
+}
+
+// ListCertificateHistory returns every archived issuance for the given LookupKey, oldest first
+//
+// The LookupKey must be a full key, see `lk.Expand()` to resolve partial keys
+func ListCertificateHistory(s3Svc s3iface.S3API, s3Bucket string, prefix string, lk *LookupKey) ([]CertificateIssuance, error) {
+	fullPrefix := historyPrefix(prefix, lk)
+	var (
+		issuances []CertificateIssuance
+		parseErr  error
+	)
+	err := s3Svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s3Bucket),
+		Prefix: aws.String(fullPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			stamp := strings.TrimSuffix(strings.TrimPrefix(*obj.Key, fullPrefix), ".json")
+			issuedOn, err := time.Parse(historyTimeFormat, stamp)
+			if err != nil {
+				parseErr = fmt.Errorf("unable to parse issuance time of history object (%s): %w", *obj.Key, err)
+				return false
+			}
+			issuances = append(issuances, CertificateIssuance{ObjectKey: *obj.Key, IssuedOn: issuedOn})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	sort.SliceStable(issuances, func(i, j int) bool {
+		return issuances[i].IssuedOn.Before(issuances[j].IssuedOn)
+	})
+	return issuances, nil
+}
+
+// LoadCertificateHistory loads every archived issuance for the given LookupKey, oldest first
+//
+// The LookupKey must be a full key, see `lk.Expand()` to resolve partial keys
+func LoadCertificateHistory(s3Svc s3iface.S3API, s3Bucket string, prefix string, lk *LookupKey) ([]*SignedCertificateS3Object, error) {
+	issuances, err := ListCertificateHistory(s3Svc, s3Bucket, prefix, lk)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(issuances))
+	for i, issuance := range issuances {
+		keys[i] = issuance.ObjectKey
+	}
+	return LoadAll[SignedCertificateS3Object](s3Svc, s3Bucket, keys, 0)
+}
+
+// historyPrefix returns the prefix every archived issuance of lk is saved under,
+// short types ("h"/"u") are expanded the same way `lk.Expand()` does
+func historyPrefix(prefix string, lk *LookupKey) string {
+	expanded := *lk
+	expanded.Type = expanded.Type.Expand()
+	return fmt.Sprintf("%s%s%s/", prefix, S3CertHistoryPrefix, &expanded)
+}
@@ -0,0 +1,99 @@
+package protocol_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestSignedCertificateS3Object_HistoryObjectKey(t *testing.T) {
+	c := &protocol.SignedCertificateS3Object{
+		CertificateType: protocol.HostCertificate,
+		Identity:        "test.example.com",
+		Principals:      []string{"test.example.com"},
+		IssuedOn:        time.Date(2022, 5, 22, 10, 30, 0, 0, time.FixedZone("EST", -5*60*60)),
+	}
+	want := "schism-test/Signed-Certs-History/host:55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d/20220522T153000.000000000Z.json"
+	if got := c.HistoryObjectKey(prefix); got != want {
+		t.Errorf("HistoryObjectKey() = %v, want %v", got, want)
+	}
+}
+
+func TestCertificateHistory(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	firstIssue := time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC)
+	var saved []*protocol.SignedCertificateS3Object
+	for i, issuedOn := range []time.Time{firstIssue, firstIssue.Add(time.Hour), firstIssue.Add(48 * time.Hour)} {
+		c := &protocol.SignedCertificateS3Object{
+			CertificateType:      protocol.HostCertificate,
+			IssuedOn:             issuedOn,
+			Identity:             "test.example.com",
+			Principals:           []string{"test.example.com"},
+			ValidityInterval:     time.Duration(i+1) * time.Hour,
+			RawSignedCertificate: []byte{byte(i)},
+		}
+		if err := c.SaveObject(s3Svc, testValidBucket, prefix); err != nil {
+			t.Fatalf("SaveObject() error = %v", err)
+		}
+		saved = append(saved, c)
+	}
+	lk := protocol.GenerateLookupKey("test.example.com", []string{"test.example.com"}, protocol.HostCertificate)
+
+	t.Run("latest issuance is kept under ObjectKey", func(t *testing.T) {
+		latest := &protocol.SignedCertificateS3Object{}
+		if err := latest.LoadObject(s3Svc, testValidBucket, saved[2].ObjectKey(prefix)); err != nil {
+			t.Fatalf("LoadObject() error = %v", err)
+		}
+		if !reflect.DeepEqual(latest, saved[2]) {
+			t.Errorf("LoadObject() got = %+v, want %+v", latest, saved[2])
+		}
+	})
+
+	t.Run("lists every issuance oldest first", func(t *testing.T) {
+		got, err := protocol.ListCertificateHistory(s3Svc, testValidBucket, prefix, lk)
+		if err != nil {
+			t.Fatalf("ListCertificateHistory() error = %v", err)
+		}
+		var want []protocol.CertificateIssuance
+		for _, c := range saved {
+			want = append(want, protocol.CertificateIssuance{ObjectKey: c.HistoryObjectKey(prefix), IssuedOn: c.IssuedOn})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ListCertificateHistory() got = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("short types are expanded", func(t *testing.T) {
+		short := &protocol.LookupKey{Id: lk.Id, Type: "h", Version: lk.Version}
+		got, err := protocol.ListCertificateHistory(s3Svc, testValidBucket, prefix, short)
+		if err != nil || len(got) != len(saved) {
+			t.Errorf("ListCertificateHistory() got = %+v, error = %v, want %d issuances", got, err, len(saved))
+		}
+	})
+
+	t.Run("loads every issuance", func(t *testing.T) {
+		got, err := protocol.LoadCertificateHistory(s3Svc, testValidBucket, prefix, lk)
+		if err != nil {
+			t.Fatalf("LoadCertificateHistory() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, saved) {
+			t.Errorf("LoadCertificateHistory() got = %+v, want %+v", got, saved)
+		}
+	})
+
+	t.Run("unknown keys have no history", func(t *testing.T) {
+		got, err := protocol.ListCertificateHistory(s3Svc, testValidBucket, prefix, &protocol.LookupKey{Id: userSomeUserDevKey, Type: protocol.UserCertificate})
+		if err != nil || len(got) != 0 {
+			t.Errorf("ListCertificateHistory() got = %+v, error = %v", got, err)
+		}
+	})
+
+	t.Run("errors from S3 are returned", func(t *testing.T) {
+		if _, err := protocol.LoadCertificateHistory(s3Svc, "this-bucket-is-a-lie", prefix, lk); err == nil {
+			t.Error("LoadCertificateHistory() expected an error")
+		}
+	})
+}
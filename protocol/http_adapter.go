@@ -0,0 +1,229 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"encoding/base64"
+	"encoding/json"
+)
+
+// RequestHandler signs a single request for caller, errors are converted with AsProtocolError
+//
+// Only the ErrorCode of internal errors reaches the client, their details are logged
+type RequestHandler func(caller *HTTPCaller, req *RequestSSHCertLambdaPayload) (*RequestSSHCertLambdaResponse, error)
+
+// HTTPCaller is what the HTTP adapters know about who sent a request,
+// handlers decide from it whether the caller may have the requested Certificate
+type HTTPCaller struct {
+	// Id API Gateway or Lambda assigned to the request, also logged with internal errors
+	RequestID string
+	// Address of the client as seen by API Gateway or the function URL
+	SourceIP string
+	// Claims or context set by the authorizer, nil without one
+	Authorizer map[string]interface{}
+}
+
+// HTTPErrorResponse is the body of every non-2xx response written by the HTTP adapters
+type HTTPErrorResponse struct {
+	Error *ProtocolError `json:"error"`
+}
+
+// APIGatewayProxyRequest is the subset of the API Gateway REST API proxy integration event the adapter reads,
+// it decodes from the same JSON as `events.APIGatewayProxyRequest` in aws-lambda-go
+type APIGatewayProxyRequest struct {
+	Resource              string                        `json:"resource"`
+	Path                  string                        `json:"path"`
+	HTTPMethod            string                        `json:"httpMethod"`
+	Headers               map[string]string             `json:"headers"`
+	MultiValueHeaders     map[string][]string           `json:"multiValueHeaders"`
+	QueryStringParameters map[string]string             `json:"queryStringParameters"`
+	RequestContext        APIGatewayProxyRequestContext `json:"requestContext"`
+	Body                  string                        `json:"body"`
+	IsBase64Encoded       bool                          `json:"isBase64Encoded"`
+}
+
+// APIGatewayProxyRequestContext carries the caller details API Gateway adds to the event
+type APIGatewayProxyRequestContext struct {
+	RequestID string                    `json:"requestId"`
+	Stage     string                    `json:"stage"`
+	Identity  APIGatewayRequestIdentity `json:"identity"`
+	// Claims or context set by the API Gateway authorizer, if any
+	Authorizer map[string]interface{} `json:"authorizer,omitempty"`
+}
+
+// APIGatewayRequestIdentity describes the client behind an APIGatewayProxyRequest
+type APIGatewayRequestIdentity struct {
+	SourceIP  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+}
+
+// APIGatewayProxyResponse is the API Gateway REST API proxy integration response
+type APIGatewayProxyResponse struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+// FunctionURLRequest is the subset of the Lambda function URL event (payload format 2.0) the adapter reads,
+// it decodes from the same JSON as `events.LambdaFunctionURLRequest` in aws-lambda-go
+type FunctionURLRequest struct {
+	Version               string                    `json:"version"`
+	RawPath               string                    `json:"rawPath"`
+	RawQueryString        string                    `json:"rawQueryString"`
+	Headers               map[string]string         `json:"headers"`
+	QueryStringParameters map[string]string         `json:"queryStringParameters"`
+	RequestContext        FunctionURLRequestContext `json:"requestContext"`
+	Body                  string                    `json:"body"`
+	IsBase64Encoded       bool                      `json:"isBase64Encoded"`
+}
+
+// FunctionURLRequestContext carries the caller details Lambda adds to the event
+type FunctionURLRequestContext struct {
+	RequestID string                        `json:"requestId"`
+	HTTP      FunctionURLRequestContextHTTP `json:"http"`
+	// Set when the function URL uses AWS_IAM auth
+	Authorizer map[string]interface{} `json:"authorizer,omitempty"`
+}
+
+// FunctionURLRequestContextHTTP describes the HTTP request behind a FunctionURLRequest
+type FunctionURLRequestContextHTTP struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	SourceIP  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+}
+
+// FunctionURLResponse is the Lambda function URL response (payload format 2.0)
+type FunctionURLResponse struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+// HTTPOptions configure HandleAPIGatewayProxy and HandleFunctionURL, nil uses the defaults
+type HTTPOptions struct {
+	// Receives the details of internal errors, which are kept from the client.
+	// Nothing is logged if nil
+	ErrorLog *log.Logger
+}
+
+// HandleAPIGatewayProxy decodes a RequestSSHCertLambdaPayload from an API Gateway proxy event,
+// runs handler and wraps the outcome in an HTTP response, see handleHTTP for the status codes
+func HandleAPIGatewayProxy(event *APIGatewayProxyRequest, handler RequestHandler, opts *HTTPOptions) *APIGatewayProxyResponse {
+	caller := &HTTPCaller{
+		RequestID:  event.RequestContext.RequestID,
+		SourceIP:   event.RequestContext.Identity.SourceIP,
+		Authorizer: event.RequestContext.Authorizer,
+	}
+	status, headers, body := handleHTTP(caller, event.HTTPMethod, event.Headers, event.Body, event.IsBase64Encoded, handler, opts)
+	return &APIGatewayProxyResponse{StatusCode: status, Headers: headers, Body: body}
+}
+
+// HandleFunctionURL is HandleAPIGatewayProxy for Lambda function URL events
+func HandleFunctionURL(event *FunctionURLRequest, handler RequestHandler, opts *HTTPOptions) *FunctionURLResponse {
+	caller := &HTTPCaller{
+		RequestID:  event.RequestContext.RequestID,
+		SourceIP:   event.RequestContext.HTTP.SourceIP,
+		Authorizer: event.RequestContext.Authorizer,
+	}
+	status, headers, body := handleHTTP(caller, event.RequestContext.HTTP.Method, event.Headers, event.Body, event.IsBase64Encoded, handler, opts)
+	return &FunctionURLResponse{StatusCode: status, Headers: headers, Body: body}
+}
+
+// handleHTTP is shared by the adapters, only POSTs of a JSON payload are accepted
+//
+//  Status codes:
+//   200 with the RequestSSHCertLambdaResponse
+//   202 with the RequestSSHCertLambdaResponse when it has a RequestId, see WaitForCertificate
+//   405, 413, 415 for requests that can't carry a payload
+//   ProtocolError.HTTPStatus with an HTTPErrorResponse for everything else, see handlerError
+func handleHTTP(caller *HTTPCaller, method string, headers map[string]string, rawBody string, isBase64Encoded bool, handler RequestHandler, opts *HTTPOptions) (int, map[string]string, string) {
+	if !strings.EqualFold(method, http.MethodPost) {
+		status, respHeaders, body := httpError(http.StatusMethodNotAllowed, NewProtocolError(ErrCodeInvalidRequest, "method %s is not allowed", method))
+		respHeaders["Allow"] = http.MethodPost
+		return status, respHeaders, body
+	}
+	if contentType := headerValue(headers, "Content-Type"); contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+			return httpError(http.StatusUnsupportedMediaType, NewProtocolError(ErrCodeInvalidRequest, "content type '%s' is not application/json", contentType))
+		}
+	}
+	body := []byte(rawBody)
+	if isBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(rawBody)
+		if err != nil {
+			return httpError(0, NewProtocolError(ErrCodeInvalidRequest, "unable to decode base64 body: %s", err))
+		}
+		body = decoded
+	}
+	if len(body) > LambdaMaxPayloadBytes {
+		return httpError(http.StatusRequestEntityTooLarge, NewProtocolError(ErrCodeInvalidRequest, "request is larger than %d bytes", LambdaMaxPayloadBytes))
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return httpError(0, NewProtocolError(ErrCodeInvalidRequest, "request body is empty"))
+	}
+	payload := &RequestSSHCertLambdaPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return httpError(0, NewProtocolError(ErrCodeInvalidRequest, "unable to decode request: %s", err))
+	}
+	resp, err := handler(caller, payload)
+	if err != nil {
+		return handlerError(caller, err, opts)
+	}
+	if resp == nil {
+		return handlerError(caller, errors.New("handler returned no response"), opts)
+	}
+	respBody, err := json.Marshal(resp)
+	if err != nil {
+		return handlerError(caller, fmt.Errorf("unable to encode response: %w", err), opts)
+	}
+	status := http.StatusOK
+	if resp.RequestId != "" {
+		status = http.StatusAccepted
+	}
+	return status, jsonHeaders(), string(respBody)
+}
+
+// handlerError converts err with AsProtocolError, which keeps the details of internal errors from the client,
+// internal errors are logged to HTTPOptions.ErrorLog
+func handlerError(caller *HTTPCaller, err error, opts *HTTPOptions) (int, map[string]string, string) {
+	pErr := AsProtocolError(err)
+	if opts != nil && opts.ErrorLog != nil && pErr.HTTPStatus() >= http.StatusInternalServerError {
+		opts.ErrorLog.Printf("request %s from %s failed: %v", caller.RequestID, caller.SourceIP, err)
+	}
+	return httpError(0, pErr)
+}
+
+// httpError writes pErr as an HTTPErrorResponse, a status of 0 uses pErr.HTTPStatus
+func httpError(status int, pErr *ProtocolError) (int, map[string]string, string) {
+	if status == 0 {
+		status = pErr.HTTPStatus()
+	}
+	body, _ := json.Marshal(&HTTPErrorResponse{Error: pErr})
+	return status, jsonHeaders(), string(body)
+}
+
+func jsonHeaders() map[string]string {
+	return map[string]string{
+		"Content-Type":  jsonContentType,
+		"Cache-Control": "no-store",
+	}
+}
+
+// headerValue looks up name case-insensitively, API Gateway keeps the case the client sent
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
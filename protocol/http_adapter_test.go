@@ -0,0 +1,213 @@
+package protocol_test
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"encoding/base64"
+	"encoding/json"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+const testHTTPPayload = `{"certificate_type":"user","certificate_identity":"alice","certificate_principals":["alice"],"validity_interval":"12h","public_key":"ssh-ed25519 AAAA"}`
+
+// testHTTPHandler echoes the request back, identities pick the outcome
+func testHTTPHandler(caller *protocol.HTTPCaller, req *protocol.RequestSSHCertLambdaPayload) (*protocol.RequestSSHCertLambdaResponse, error) {
+	switch req.Identity {
+	case "mallory":
+		return nil, protocol.NewProtocolError(protocol.ErrCodeAccessDenied, "identity %s is not allowed", req.Identity)
+	case "broken":
+		return nil, errors.New("s3 is down")
+	case "nobody":
+		return nil, nil
+	case "async":
+		return &protocol.RequestSSHCertLambdaResponse{RequestId: "0123"}, nil
+	}
+	return &protocol.RequestSSHCertLambdaResponse{CertificateType: req.CertificateType, LookupKey: req.Identity}, nil
+}
+
+func TestHandleAPIGatewayProxy(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      protocol.APIGatewayProxyRequest
+		wantStatus int
+		wantCode   protocol.ErrorCode
+	}{
+		{
+			name:       "valid",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"content-type": "application/json; charset=utf-8"}, Body: testHTTPPayload},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "base64 body",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Body: base64.StdEncoding.EncodeToString([]byte(testHTTPPayload)), IsBase64Encoded: true},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "asynchronous",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Body: strings.Replace(testHTTPPayload, "alice", "async", 1)},
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "wrong method",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "GET"},
+			wantStatus: http.StatusMethodNotAllowed, wantCode: protocol.ErrCodeInvalidRequest,
+		},
+		{
+			name:       "wrong content type",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"Content-Type": "text/plain"}, Body: testHTTPPayload},
+			wantStatus: http.StatusUnsupportedMediaType, wantCode: protocol.ErrCodeInvalidRequest,
+		},
+		{
+			name:       "too large",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Body: strings.Repeat(" ", protocol.LambdaMaxPayloadBytes+1)},
+			wantStatus: http.StatusRequestEntityTooLarge, wantCode: protocol.ErrCodeInvalidRequest,
+		},
+		{
+			name:       "empty body",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST"},
+			wantStatus: http.StatusBadRequest, wantCode: protocol.ErrCodeInvalidRequest,
+		},
+		{
+			name:       "bad base64",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Body: "{", IsBase64Encoded: true},
+			wantStatus: http.StatusBadRequest, wantCode: protocol.ErrCodeInvalidRequest,
+		},
+		{
+			name:       "invalid certificate type",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Body: strings.Replace(testHTTPPayload, `"user"`, `"root"`, 1)},
+			wantStatus: http.StatusBadRequest, wantCode: protocol.ErrCodeInvalidRequest,
+		},
+		{
+			name:       "access denied",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Body: strings.Replace(testHTTPPayload, "alice", "mallory", 1)},
+			wantStatus: http.StatusForbidden, wantCode: protocol.ErrCodeAccessDenied,
+		},
+		{
+			name:       "internal error",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Body: strings.Replace(testHTTPPayload, "alice", "broken", 1)},
+			wantStatus: http.StatusInternalServerError, wantCode: protocol.ErrCodeInternal,
+		},
+		{
+			name:       "no response",
+			event:      protocol.APIGatewayProxyRequest{HTTPMethod: "POST", Body: strings.Replace(testHTTPPayload, "alice", "nobody", 1)},
+			wantStatus: http.StatusInternalServerError, wantCode: protocol.ErrCodeInternal,
+		},
+	}
+	var logged bytes.Buffer
+	opts := &protocol.HTTPOptions{ErrorLog: log.New(&logged, "", 0)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := protocol.HandleAPIGatewayProxy(&tt.event, testHTTPHandler, opts)
+			if got.StatusCode != tt.wantStatus {
+				t.Errorf("HandleAPIGatewayProxy() StatusCode = %v, want %v (%s)", got.StatusCode, tt.wantStatus, got.Body)
+			}
+			if got.Headers["Content-Type"] != "application/json" {
+				t.Errorf("HandleAPIGatewayProxy() Content-Type = %v, want application/json", got.Headers["Content-Type"])
+			}
+			if tt.wantCode == "" {
+				resp := &protocol.RequestSSHCertLambdaResponse{}
+				if err := json.Unmarshal([]byte(got.Body), resp); err != nil || (resp.CertificateType != protocol.UserCertificate && resp.RequestId == "") {
+					t.Errorf("HandleAPIGatewayProxy() Body = %s, error = %v", got.Body, err)
+				}
+				return
+			}
+			errResp := &protocol.HTTPErrorResponse{}
+			if err := json.Unmarshal([]byte(got.Body), errResp); err != nil || errResp.Error == nil || errResp.Error.Code != tt.wantCode {
+				t.Errorf("HandleAPIGatewayProxy() Body = %s, want code %v", got.Body, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("internal error details are logged, not returned", func(t *testing.T) {
+		logged.Reset()
+		event := &protocol.APIGatewayProxyRequest{
+			HTTPMethod:     "POST",
+			Body:           strings.Replace(testHTTPPayload, "alice", "broken", 1),
+			RequestContext: protocol.APIGatewayProxyRequestContext{RequestID: "req-1"},
+		}
+		got := protocol.HandleAPIGatewayProxy(event, testHTTPHandler, opts)
+		if strings.Contains(got.Body, "s3 is down") {
+			t.Errorf("HandleAPIGatewayProxy() Body = %s, want a generic message", got.Body)
+		}
+		if !strings.Contains(logged.String(), "req-1") || !strings.Contains(logged.String(), "s3 is down") {
+			t.Errorf("HandleAPIGatewayProxy() logged %q, want the request id and error", logged.String())
+		}
+	})
+
+	t.Run("handler receives the caller", func(t *testing.T) {
+		raw := `{
+  "httpMethod": "POST",
+  "requestContext": {
+    "requestId": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+    "stage": "prod",
+    "identity": {"sourceIp": "198.51.100.1", "userAgent": "curl/7.79.1"},
+    "authorizer": {"claims": {"sub": "alice"}}
+  },
+  "body": ` + strconv.Quote(testHTTPPayload) + `
+}`
+		event := &protocol.APIGatewayProxyRequest{}
+		if err := json.Unmarshal([]byte(raw), event); err != nil {
+			t.Fatal(err)
+		}
+		var got *protocol.HTTPCaller
+		protocol.HandleAPIGatewayProxy(event, func(caller *protocol.HTTPCaller, req *protocol.RequestSSHCertLambdaPayload) (*protocol.RequestSSHCertLambdaResponse, error) {
+			got = caller
+			return testHTTPHandler(caller, req)
+		}, nil)
+		want := &protocol.HTTPCaller{
+			RequestID:  "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+			SourceIP:   "198.51.100.1",
+			Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "alice"}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("HandleAPIGatewayProxy() caller = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestHandleFunctionURL(t *testing.T) {
+	raw := `{
+  "version": "2.0",
+  "rawPath": "/",
+  "rawQueryString": "",
+  "headers": {"content-type": "application/json", "host": "abc.lambda-url.us-east-1.on.aws"},
+  "requestContext": {
+    "requestId": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+    "http": {"method": "POST", "path": "/", "protocol": "HTTP/1.1", "sourceIp": "198.51.100.1", "userAgent": "curl/7.79.1"}
+  },
+  "body": "` + base64.StdEncoding.EncodeToString([]byte(testHTTPPayload)) + `",
+  "isBase64Encoded": true
+}`
+	event := &protocol.FunctionURLRequest{}
+	if err := json.Unmarshal([]byte(raw), event); err != nil {
+		t.Fatal(err)
+	}
+	var caller *protocol.HTTPCaller
+	got := protocol.HandleFunctionURL(event, func(c *protocol.HTTPCaller, req *protocol.RequestSSHCertLambdaPayload) (*protocol.RequestSSHCertLambdaResponse, error) {
+		caller = c
+		return testHTTPHandler(c, req)
+	}, nil)
+	if want := (&protocol.HTTPCaller{RequestID: "c6af9ac6-7b61-11e6-9a41-93e8deadbeef", SourceIP: "198.51.100.1"}); !reflect.DeepEqual(caller, want) {
+		t.Errorf("HandleFunctionURL() caller = %+v, want %+v", caller, want)
+	}
+	if got.StatusCode != http.StatusOK {
+		t.Fatalf("HandleFunctionURL() StatusCode = %v, want %v (%s)", got.StatusCode, http.StatusOK, got.Body)
+	}
+	resp := &protocol.RequestSSHCertLambdaResponse{}
+	if err := json.Unmarshal([]byte(got.Body), resp); err != nil || resp.LookupKey != "alice" {
+		t.Errorf("HandleFunctionURL() Body = %s, error = %v", got.Body, err)
+	}
+
+	event.RequestContext.HTTP.Method = "PUT"
+	if got = protocol.HandleFunctionURL(event, testHTTPHandler, nil); got.StatusCode != http.StatusMethodNotAllowed || got.Headers["Allow"] != http.MethodPost {
+		t.Errorf("HandleFunctionURL() = %v %v, want %v", got.StatusCode, got.Headers, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"crypto/rsa"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrKeyRejected is wrapped by every error returned when a public key fails a KeyPolicy
+var ErrKeyRejected = errors.New("public key rejected")
+
+// DefaultMinRSABits is the smallest RSA modulus DefaultKeyPolicy accepts
+const DefaultMinRSABits = 3072
+
+// keyTypeBits are the sizes of the fixed-size key types
+var keyTypeBits = map[string]int{
+	ssh.KeyAlgoED25519:    256,
+	ssh.KeyAlgoSKED25519:  256,
+	ssh.KeyAlgoECDSA256:   256,
+	ssh.KeyAlgoSKECDSA256: 256,
+	ssh.KeyAlgoECDSA384:   384,
+	ssh.KeyAlgoECDSA521:   521,
+}
+
+// KeyPolicy decides which submitted public keys the CA is willing to sign
+type KeyPolicy struct {
+	// Key types (as returned by ssh.PublicKey.Type()) that may be signed.
+	// DSA keys are always rejected.
+	AllowedTypes []string
+	// Smallest RSA modulus in bits that may be signed
+	MinRSABits int
+}
+
+// DefaultKeyPolicy allows ed25519, ecdsa P-256/384/521, the security-key variants
+// and RSA keys of at least DefaultMinRSABits
+func DefaultKeyPolicy() *KeyPolicy {
+	return &KeyPolicy{
+		AllowedTypes: []string{
+			ssh.KeyAlgoED25519,
+			ssh.KeyAlgoSKED25519,
+			ssh.KeyAlgoECDSA256,
+			ssh.KeyAlgoECDSA384,
+			ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoSKECDSA256,
+			ssh.KeyAlgoRSA,
+		},
+		MinRSABits: DefaultMinRSABits,
+	}
+}
+
+// PublicKeyInfo describes a submitted public key
+type PublicKeyInfo struct {
+	// Key type as returned by ssh.PublicKey.Type()
+	Type string `json:"key_type"`
+	// Size of the key in bits
+	Bits int `json:"key_bits"`
+	// The Fingerprint of the PublicKey as returned by ssh.FingerprintSHA256
+	Fingerprint string `json:"key_fingerprint"`
+}
+
+// ParsePublicKeyInfo parses a public key in authorized_keys format and describes it
+//
+// Returns an error if the key cannot be parsed
+func ParsePublicKeyInfo(authorizedKey string) (*PublicKeyInfo, ssh.PublicKey, error) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse public key: %w", err)
+	}
+	info := &PublicKeyInfo{
+		Type:        pubKey.Type(),
+		Bits:        keyTypeBits[pubKey.Type()],
+		Fingerprint: ssh.FingerprintSHA256(pubKey),
+	}
+	if cryptoKey, ok := pubKey.(ssh.CryptoPublicKey); ok {
+		if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok {
+			info.Bits = rsaKey.N.BitLen()
+		}
+	}
+	return info, pubKey, nil
+}
+
+// Check parses a public key in authorized_keys format and enforces the policy
+//
+// Returns the detected key type, size and fingerprint,
+// or an error wrapping ErrKeyRejected if the key isn't allowed
+func (p *KeyPolicy) Check(authorizedKey string) (*PublicKeyInfo, error) {
+	info, pubKey, err := ParsePublicKeyInfo(authorizedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyRejected, err)
+	}
+	if _, isCert := pubKey.(*ssh.Certificate); isCert {
+		return info, fmt.Errorf("%w: %s is a certificate, submit the plain public key", ErrKeyRejected, info.Type)
+	}
+	if info.Type == ssh.KeyAlgoDSA {
+		return info, fmt.Errorf("%w: DSA keys are not supported", ErrKeyRejected)
+	}
+	allowed := false
+	for _, keyType := range p.AllowedTypes {
+		allowed = allowed || keyType == info.Type
+	}
+	if !allowed {
+		return info, fmt.Errorf("%w: key type %s is not one of %s", ErrKeyRejected, info.Type, strings.Join(p.AllowedTypes, ", "))
+	}
+	if info.Type == ssh.KeyAlgoRSA && info.Bits < p.MinRSABits {
+		return info, fmt.Errorf("%w: RSA key is %d bits, at least %d are required", ErrKeyRejected, info.Bits, p.MinRSABits)
+	}
+	return info, nil
+}
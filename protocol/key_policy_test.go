@@ -0,0 +1,130 @@
+package protocol_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"crypto/ed25519"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// skEd25519AuthorizedKey builds an sk-ssh-ed25519 public key, these can't be generated without a security key
+func skEd25519AuthorizedKey(t *testing.T) string {
+	t.Helper()
+	wire := ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, make([]byte, ed25519.PublicKeySize), "ssh:"})
+	pubKey, err := ssh.ParsePublicKey(wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(pubKey))
+}
+
+func TestKeyPolicy_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *protocol.KeyPolicy
+		key     string
+		want    *protocol.PublicKeyInfo
+		wantErr bool
+	}{
+		{
+			name:   "ed25519 is allowed",
+			policy: protocol.DefaultKeyPolicy(),
+			key:    helperLoadString(t, "public_keys/ed25519.pub"),
+			want: &protocol.PublicKeyInfo{
+				Type:        ssh.KeyAlgoED25519,
+				Bits:        256,
+				Fingerprint: "SHA256:Vgc8fXuGL3CaN0vNUKgcI1kscOMyJ0Ooe4PhWchmQOA",
+			},
+		},
+		{
+			name:   "ecdsa P-384 is allowed",
+			policy: protocol.DefaultKeyPolicy(),
+			key:    helperLoadString(t, "public_keys/ecdsa_384.pub"),
+			want: &protocol.PublicKeyInfo{
+				Type:        ssh.KeyAlgoECDSA384,
+				Bits:        384,
+				Fingerprint: "SHA256:VLF/hBNvvwgrl/CX6DGrPrdsPYp2vDg7hG6bhvC8lBg",
+			},
+		},
+		{
+			name:   "security keys are allowed",
+			policy: protocol.DefaultKeyPolicy(),
+			key:    skEd25519AuthorizedKey(t),
+			want: &protocol.PublicKeyInfo{
+				Type:        ssh.KeyAlgoSKED25519,
+				Bits:        256,
+				Fingerprint: "SHA256:6018Yn06g8dSpfsOYvwaTu4C1xQeBZUhQlUwkmJP/LU",
+			},
+		},
+		{
+			name:   "rsa 3072 is allowed",
+			policy: protocol.DefaultKeyPolicy(),
+			key:    helperLoadString(t, "public_keys/rsa_3072.pub"),
+			want: &protocol.PublicKeyInfo{
+				Type:        ssh.KeyAlgoRSA,
+				Bits:        3072,
+				Fingerprint: "SHA256:8SQOX9ebYWyKdXcHORmRARQ6ZlhdOM/Wxk9ZRkMWM6Q",
+			},
+		},
+		{
+			name:    "rsa 2048 is too small",
+			policy:  protocol.DefaultKeyPolicy(),
+			key:     helperLoadString(t, "public_keys/rsa_2048.pub"),
+			wantErr: true,
+		},
+		{
+			name:   "rsa minimum is configurable",
+			policy: &protocol.KeyPolicy{AllowedTypes: []string{ssh.KeyAlgoRSA}, MinRSABits: 2048},
+			key:    helperLoadString(t, "public_keys/rsa_2048.pub"),
+			want: &protocol.PublicKeyInfo{
+				Type:        ssh.KeyAlgoRSA,
+				Bits:        2048,
+				Fingerprint: "SHA256:UQCrhlkPHXbxtSwQwuf9xUIzcRI2pr/tUmS9Yx1JxdQ",
+			},
+		},
+		{
+			name:    "dsa is always rejected",
+			policy:  &protocol.KeyPolicy{AllowedTypes: []string{ssh.KeyAlgoDSA}},
+			key:     helperLoadString(t, "public_keys/dsa.pub"),
+			wantErr: true,
+		},
+		{
+			name:    "types outside the allowlist are rejected",
+			policy:  &protocol.KeyPolicy{AllowedTypes: []string{ssh.KeyAlgoED25519}},
+			key:     helperLoadString(t, "public_keys/ecdsa_384.pub"),
+			wantErr: true,
+		},
+		{
+			name:    "garbage is rejected",
+			policy:  protocol.DefaultKeyPolicy(),
+			key:     "ssh-ed25519 not-base64",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Check(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, protocol.ErrKeyRejected) {
+					t.Errorf("Check() error = %v, want it to wrap ErrKeyRejected", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
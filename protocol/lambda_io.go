@@ -15,21 +15,50 @@ type RequestSSHCertLambdaPayload struct {
 	// Specify one or more principals (user or host names) to be included in a certificate when signing a key.
 	Principals []string `json:"certificate_principals"`
 	// Length of time the Signed Certificate will be valid for.
+	//
+	// Ignored when ValidBefore is set.
 	ValidityInterval time.Duration `json:"validity_interval"`
-	// Specify a certificate option when signing a key.
-	UserKeyOptions []string `json:"user_key_options,omitempty"`
-	// Public Key to submit to the CA for signing.
-	// Supported types: (Others may work, ymmv)
+	// Optional start of the validity window, defaults to "now" minus the CA's backdate allowance.
+	ValidAfter *time.Time `json:"valid_after,omitempty"`
+	// Optional end of the validity window, defaults to the start plus ValidityInterval.
+	ValidBefore *time.Time `json:"valid_before,omitempty"`
+	// Critical options and extensions to include when signing a user key,
+	// nil leaves the choice to the CA. See CertificateOptions for the JSON format.
+	CertificateOptions *CertificateOptions `json:"user_key_options,omitempty"`
+	// Public Key to submit to the CA for signing, in authorized_keys format.
+	// Accepted types are decided by the CA's KeyPolicy, see DefaultKeyPolicy:
 	//
-	//    * "ed25519"
-	//    * "rsa"
+	//    * "ed25519" and "sk-ed25519"
+	//    * "ecdsa" P-256/384/521 and "sk-ecdsa" P-256
+	//    * "rsa" (3072 bits or more)
 	PublicKey string `json:"public_key"`
+	// Encoding used when marshaling, SchemaV2 writes ValidityInterval as a readable Duration
+	SchemaVersion SchemaVersion `json:"schema_version,omitempty"`
+}
+
+// Validate checks the payload asks for a type Schism signs, "host" or "user"
+//
+// Called when the payload is encoded or decoded
+func (p *RequestSSHCertLambdaPayload) Validate() error {
+	return p.CertificateType.requireSignable()
 }
 
 // RequestSSHCertLambdaResponse is used to return pertinent information from the lambda function
 type RequestSSHCertLambdaResponse struct {
-	// Type of SSH-cert that was generated.
-	CertificateType CertType `json:"certificate_type"`
+	// Type of SSH-cert that was generated, unset while an asynchronous request is pending
+	CertificateType CertType `json:"certificate_type,omitempty"`
 	// 64-character key used with the CertType to fetch the Certificate bundle from S3
 	LookupKey string `json:"lookup_key"`
+	// Set when the request is handled asynchronously, see WaitForCertificate
+	RequestId string `json:"request_id,omitempty"`
+	// Serial number of the Certificate, if the CA allocates serials
+	Serial uint64 `json:"serial,omitempty"`
+	// Key type of the submitted PublicKey as detected by the CA
+	PublicKeyType string `json:"public_key_type,omitempty"`
+	// The Fingerprint of the submitted PublicKey as returned by ssh.FingerprintSHA256
+	PublicKeyFingerprint string `json:"public_key_fingerprint,omitempty"`
+	// Optional short-lived presigned GET URL for the Certificate, see DownloadCertificate
+	CertificateURL string `json:"certificate_url,omitempty"`
+	// When CertificateURL stops working
+	CertificateURLExpiresOn *time.Time `json:"certificate_url_expires_on,omitempty"`
 }
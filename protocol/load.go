@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// DefaultLoadConcurrency is how many objects LoadAll fetches at once when no concurrency is given
+const DefaultLoadConcurrency = 16
+
+// s3ObjectPtr is satisfied by pointers to the S3Object structs in this package,
+// it lets the generic helpers allocate a T and still call the S3Object methods on it
+type s3ObjectPtr[T any] interface {
+	*T
+	S3Object
+}
+
+// Load allocates a T and populates it from s3://{s3Bucket}/{s3ObjectKey} with LoadObject
+//
+//  Example:
+//   cert, err := protocol.Load[protocol.SignedCertificateS3Object](s3Svc, bucket, key)
+func Load[T any, PT s3ObjectPtr[T]](s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string) (*T, error) {
+	obj := new(T)
+	if err := PT(obj).LoadObject(s3Svc, s3Bucket, s3ObjectKey); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// LoadByLookupKey expands a (possibly partial) LookupKey and loads the latest Certificate saved under it
+//
+// lk is not modified, call `lk.Expand()` yourself if the full key is needed
+func LoadByLookupKey(s3Svc s3iface.S3API, s3Bucket string, prefix string, lk *LookupKey) (*SignedCertificateS3Object, error) {
+	expanded := *lk
+	if err := expanded.Expand(s3Svc, s3Bucket, prefix); err != nil {
+		return nil, err
+	}
+	return Load[SignedCertificateS3Object](s3Svc, s3Bucket, expanded.ObjectKey(prefix))
+}
+
+// LoadAll loads every key into a T, with at most concurrency fetches in flight
+// (DefaultLoadConcurrency if <= 0)
+//
+// Objects are returned in the same order as keys,
+// if any fetch fails the first error (by key order) is returned
+func LoadAll[T any, PT s3ObjectPtr[T]](s3Svc s3iface.S3API, s3Bucket string, keys []string, concurrency int) ([]*T, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultLoadConcurrency
+	}
+	objs := make([]*T, len(keys))
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, key string) {
+			defer func() { <-sem; wg.Done() }()
+			objs[i], errs[i] = Load[T, PT](s3Svc, s3Bucket, key)
+		}(i, key)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+// ListObjectKeys returns every object key in s3Bucket that starts with fullPrefix, in lexical order
+func ListObjectKeys(s3Svc s3iface.S3API, s3Bucket string, fullPrefix string) ([]string, error) {
+	var keys []string
+	err := s3Svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s3Bucket),
+		Prefix: aws.String(fullPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// LoadAllWithPrefix lists fullPrefix and loads every object under it with LoadAll
+//
+//  Example:
+//   certs, err := protocol.LoadAllWithPrefix[protocol.SignedCertificateS3Object](
+//   	s3Svc, bucket, prefix+protocol.S3CertStoragePrefix, 0)
+func LoadAllWithPrefix[T any, PT s3ObjectPtr[T]](s3Svc s3iface.S3API, s3Bucket string, fullPrefix string, concurrency int) ([]*T, error) {
+	keys, err := ListObjectKeys(s3Svc, s3Bucket, fullPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return LoadAll[T, PT](s3Svc, s3Bucket, keys, concurrency)
+}
@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// DefaultMaxObjectSize is the largest object rawLoadS3Object reads by default,
+// Schism objects are a few KiB so anything close to this is not ours
+const DefaultMaxObjectSize int64 = 1 << 20
+
+// ChecksumMetadataKey is the S3 user metadata key holding the hex sha256 of the object body,
+// saved with every object and verified on load whenever present
+const ChecksumMetadataKey = "Schism-Sha256"
+
+const jsonContentType = "application/json"
+
+// Errors returned (wrapped) when loading S3 objects
+var (
+	ErrEmptyObject           = errors.New("s3 object is empty")
+	ErrObjectTooLarge        = errors.New("s3 object is too large")
+	ErrUnexpectedContentType = errors.New("s3 object is not JSON")
+	ErrChecksumMismatch      = errors.New("s3 object checksum mismatch")
+)
+
+// LoadOptions controls the checks every LoadObject makes on the raw object
+type LoadOptions struct {
+	// Objects larger than this are rejected with ErrObjectTooLarge, <= 0 disables the limit
+	MaxSize int64
+	// Reject objects whose ContentType isn't application/json with ErrUnexpectedContentType
+	CheckContentType bool
+	// Reject objects without a ChecksumMetadataKey entry,
+	// checksums that are present are always verified
+	RequireChecksum bool
+}
+
+// DefaultLoadOptions returns the LoadOptions used whenever nil LoadOptions are given, including by every LoadObject.
+// Pass LoadOptions to LoadObjectWithOptions to tighten the checks
+// (e.g. once every object has been re-saved with a checksum)
+func DefaultLoadOptions() LoadOptions {
+	return LoadOptions{MaxSize: DefaultMaxObjectSize}
+}
+
+// orDefault returns a copy of the options, DefaultLoadOptions for nil
+func (o *LoadOptions) orDefault() LoadOptions {
+	if o == nil {
+		return DefaultLoadOptions()
+	}
+	return *o
+}
+
+// LoadObjectWithOptions is LoadObject for any S3Object with the raw object checked against opts
+// (DefaultLoadOptions if nil)
+func LoadObjectWithOptions(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, obj S3Object, opts *LoadOptions) error {
+	body, err := rawLoadS3Object(s3Svc, s3Bucket, s3ObjectKey, opts)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, obj); err != nil {
+		return fmt.Errorf("unable to unmarshal object (%s): %w", s3ObjectKey, err)
+	}
+	return nil
+}
+
+// readObjectBody reads an object body of (up to) contentLength bytes, -1 if unknown,
+// and checks it, its contentType and checksum metadata against opts
+func readObjectBody(name string, r io.Reader, contentLength int64, contentType string, metadata map[string]*string, opts LoadOptions) ([]byte, error) {
+	if opts.MaxSize > 0 && contentLength > opts.MaxSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, limit is %d", ErrObjectTooLarge, name, contentLength, opts.MaxSize)
+	}
+	if opts.MaxSize > 0 {
+		r = io.LimitReader(r, opts.MaxSize+1)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read object (%s): %w", name, err)
+	}
+	if opts.MaxSize > 0 && int64(len(body)) > opts.MaxSize {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrObjectTooLarge, name, opts.MaxSize)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyObject, name)
+	}
+	if opts.CheckContentType && !isJSONContentType(contentType) {
+		return nil, fmt.Errorf("%w: %s has content type '%s'", ErrUnexpectedContentType, name, contentType)
+	}
+	if err = verifyChecksum(name, body, metadata, opts.RequireChecksum); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func checksum(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == jsonContentType
+}
+
+// verifyChecksum compares body to the ChecksumMetadataKey entry in metadata
+func verifyChecksum(s3ObjectKey string, body []byte, metadata map[string]*string, required bool) error {
+	value, ok := metadataValue(metadata, ChecksumMetadataKey)
+	if !ok {
+		if required {
+			return fmt.Errorf("%w: %s has no %s metadata", ErrChecksumMismatch, s3ObjectKey, ChecksumMetadataKey)
+		}
+		return nil
+	}
+	if got := checksum(body); !strings.EqualFold(value, got) {
+		return fmt.Errorf("%w: %s has sha256 %s, metadata says %s", ErrChecksumMismatch, s3ObjectKey, got, value)
+	}
+	return nil
+}
+
+// metadataValue looks up an S3 user metadata entry,
+// keys are matched case-insensitively since S3 canonicalizes them as HTTP headers
+func metadataValue(metadata map[string]*string, name string) (string, bool) {
+	for key, value := range metadata {
+		if strings.EqualFold(key, name) && value != nil {
+			return *value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,85 @@
+package protocol_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// brokenBodyS3 returns bodies that fail partway through reading and records whether they were closed
+type brokenBodyS3 struct {
+	*fakeaws.S3
+	closed bool
+}
+
+func (b *brokenBodyS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: b}, nil
+}
+
+func (b *brokenBodyS3) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+func (b *brokenBodyS3) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestLoadOptions(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	saved := &protocol.RequestStatusS3Object{RequestId: "abc", Status: protocol.StatusPending}
+	if err := saved.SaveObject(s3Svc, testValidBucket, prefix); err != nil {
+		t.Fatalf("SaveObject() error = %v", err)
+	}
+	savedKey := saved.ObjectKey(prefix)
+	_ = s3Svc.Put(testValidBucket, "plain.json", []byte(`{"request_id":"abc"}`))
+	_ = s3Svc.Put(testValidBucket, "empty.json", nil)
+	_ = s3Svc.Put(testValidBucket, "large.json", []byte(`{"request_id":"`+strings.Repeat("a", 100)+`"}`))
+	_, _ = s3Svc.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String(testValidBucket),
+		Key:      aws.String("tampered.json"),
+		Body:     strings.NewReader(`{"request_id":"abc"}`),
+		Metadata: map[string]*string{"schism-sha256": aws.String("00")},
+	})
+
+	tests := []struct {
+		name    string
+		opts    *protocol.LoadOptions
+		key     string
+		wantErr error
+	}{
+		{name: "saved object passes every check", opts: &protocol.LoadOptions{MaxSize: 1024, CheckContentType: true, RequireChecksum: true}, key: savedKey},
+		{name: "plain object passes defaults", key: "plain.json"},
+		{name: "empty object", key: "empty.json", wantErr: protocol.ErrEmptyObject},
+		{name: "too large", opts: &protocol.LoadOptions{MaxSize: 64}, key: "large.json", wantErr: protocol.ErrObjectTooLarge},
+		{name: "no limit", opts: &protocol.LoadOptions{}, key: "large.json"},
+		{name: "missing content type", opts: &protocol.LoadOptions{CheckContentType: true}, key: "plain.json", wantErr: protocol.ErrUnexpectedContentType},
+		{name: "missing checksum", opts: &protocol.LoadOptions{RequireChecksum: true}, key: "plain.json", wantErr: protocol.ErrChecksumMismatch},
+		{name: "tampered checksum", key: "tampered.json", wantErr: protocol.ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &protocol.RequestStatusS3Object{}
+			err := protocol.LoadObjectWithOptions(s3Svc, testValidBucket, tt.key, c, tt.opts)
+			if (err != nil) != (tt.wantErr != nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("LoadObjectWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("read errors are returned and the body closed", func(t *testing.T) {
+		broken := &brokenBodyS3{S3: s3Svc}
+		c := &protocol.RequestStatusS3Object{}
+		if err := c.LoadObject(broken, testValidBucket, savedKey); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("LoadObject() error = %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+		if !broken.closed {
+			t.Errorf("LoadObject() did not close the body")
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package protocol_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestLoad(t *testing.T) {
+	s3Svc := newTestS3(t)
+	got, err := protocol.Load[protocol.CAPublicKeyS3Object](s3Svc, testValidBucket, protocol.S3CaPubkeyPrefix+"user.json")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.CertificateType != protocol.UserCertificate {
+		t.Errorf("Load() CertificateType = %v, want %v", got.CertificateType, protocol.UserCertificate)
+	}
+	if _, err = protocol.Load[protocol.CAPublicKeyS3Object](s3Svc, testValidBucket, "missing.json"); err == nil {
+		t.Errorf("Load() error = %v, wantErr %v", err, true)
+	}
+}
+
+func TestLoadByLookupKey(t *testing.T) {
+	s3Svc := newTestS3(t)
+	tests := []struct {
+		name    string
+		lk      *protocol.LookupKey
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "partial key",
+			lk:   &protocol.LookupKey{Id: "55e8182e", Type: "h"},
+			want: "test.example.com",
+		},
+		{
+			name: "full key",
+			lk:   &protocol.LookupKey{Id: "55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d", Type: protocol.HostCertificate},
+			want: "test.example.com",
+		},
+		{
+			name:    "ambiguous key",
+			lk:      &protocol.LookupKey{Id: "4", Type: protocol.UserCertificate},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := protocol.LoadByLookupKey(s3Svc, testValidBucket, "", tt.lk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadByLookupKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Identity != tt.want {
+				t.Errorf("LoadByLookupKey() Identity = %v, want %v", got.Identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAllWithPrefix(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	issuedOn := time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC)
+	var want []string
+	for _, ident := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		c := &protocol.SignedCertificateS3Object{
+			CertificateType: protocol.HostCertificate,
+			IssuedOn:        issuedOn,
+			Identity:        ident,
+			Principals:      []string{ident},
+		}
+		if err := c.SaveObject(s3Svc, testValidBucket, prefix); err != nil {
+			t.Fatalf("SaveObject() error = %v", err)
+		}
+		want = append(want, c.ObjectKey(prefix))
+	}
+
+	certs, err := protocol.LoadAllWithPrefix[protocol.SignedCertificateS3Object](s3Svc, testValidBucket, prefix+protocol.S3CertStoragePrefix, 2)
+	if err != nil {
+		t.Fatalf("LoadAllWithPrefix() error = %v", err)
+	}
+	keys, _ := protocol.ListObjectKeys(s3Svc, testValidBucket, prefix+protocol.S3CertStoragePrefix)
+	var got []string
+	for _, c := range certs {
+		got = append(got, c.ObjectKey(prefix))
+	}
+	if !reflect.DeepEqual(got, keys) || len(got) != len(want) {
+		t.Errorf("LoadAllWithPrefix() got = %v, want %v", got, keys)
+	}
+
+	if _, err = protocol.LoadAll[protocol.SignedCertificateS3Object](s3Svc, testValidBucket, append(keys, "missing.json"), 0); err == nil {
+		t.Errorf("LoadAll() error = %v, wantErr %v", err, true)
+	}
+}
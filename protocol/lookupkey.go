@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -12,21 +13,50 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
-// LookupKeySeparator is used to separate the cert type and the cert key
+// LookupKeySeparator is used to separate the cert type, version and the cert key
 const LookupKeySeparator = ":"
 
+// ErrLookupKeyNotFound is returned (wrapped) by Expand when a partial key matches zero certificates
+var ErrLookupKeyNotFound = errors.New("no certificate matches lookup key")
+
+// LookupKeyVersion identifies the scheme used to generate the Id of a LookupKey
+type LookupKeyVersion string
+
+// Known LookupKey schemes
+const (
+	// Hashes the Identity and Principals, see GenerateLookupKey
+	//
+	// This is the zero value so keys created before versioning keep working
+	LookupKeyV1 LookupKeyVersion = ""
+	// Hashes the CertType, public key fingerprint, Identity and Principals, see GenerateLookupKeyV2
+	LookupKeyV2 LookupKeyVersion = "v2"
+)
+
 // LookupKey is used for storing certificates in S3, 64-character sha256sum
 //
 // Partial keys are allowed, use lk.Expand() to attempt to fetch the full key
 type LookupKey struct {
-	Id   string
-	Type CertType
+	Id      string
+	Type    CertType
+	Version LookupKeyVersion
 }
 
 // String returns the LookupKey as a string in the format:
-//  "#{lk.Type}:#{lk.Id}"
+//  "#{lk.Type}:#{lk.Id}"            (v1)
+//  "#{lk.Type}:#{lk.Version}:#{lk.Id}" (v2 and later)
 func (lk *LookupKey) String() string {
-	return fmt.Sprintf("%s%s%s", lk.Type, LookupKeySeparator, lk.Id)
+	if lk.Version == LookupKeyV1 {
+		return fmt.Sprintf("%s%s%s", lk.Type, LookupKeySeparator, lk.Id)
+	}
+	return fmt.Sprintf("%s%s%s%s%s", lk.Type, LookupKeySeparator, lk.Version, LookupKeySeparator, lk.Id)
+}
+
+// ObjectKey given a prefix, return the key the latest Certificate for this LookupKey is saved under
+//
+//  Format:
+//   {prefix}{S3CertStoragePrefix}{lk.String()}.json
+func (lk *LookupKey) ObjectKey(prefix string) string {
+	return fmt.Sprintf("%s%s%s.json", prefix, S3CertStoragePrefix, lk)
 }
 
 // MarshalJSON returns the same thing as String but as a `[]byte`
@@ -41,12 +71,13 @@ func (lk *LookupKey) MarshalJSON() ([]byte, error) {
 //
 // Returns an error if the raw data cannot be parsed
 func (lk *LookupKey) UnmarshalJSON(data []byte) error {
-	id, cType, err := parseRawLookupKey(string(data))
+	id, cType, version, err := parseRawLookupKey(string(data))
 	if err != nil {
 		return err
 	}
 	lk.Id = id
 	lk.Type = cType
+	lk.Version = version
 	return nil
 }
 
@@ -54,7 +85,8 @@ func (lk *LookupKey) UnmarshalJSON(data []byte) error {
 // given a partial key that matches a singular certificate bundle of the given type
 // stored in the given S3 bucket (and prefix)
 //
-// Returns an error if there is not a singular match or S3 calls fail.
+// Returns an error if there is not a singular match or S3 calls fail,
+// an error wrapping ErrLookupKeyNotFound if nothing matches.
 // Returns an error if the expanded key is in an invalid format
 //
 //  Example:
@@ -80,27 +112,36 @@ func (lk *LookupKey) Expand(s3Svc s3iface.S3API, s3Bucket string, s3Prefix strin
 	case count == 1:
 		expndPrts := strings.Split(*objs.Contents[0].Key, "/")
 		expnd := strings.Split(expndPrts[len(expndPrts)-1], ".")[0]
-		lk.Id, lk.Type, err = parseRawLookupKey(expnd)
+		lk.Id, lk.Type, lk.Version, err = parseRawLookupKey(expnd)
 		return err
 	default:
-		return fmt.Errorf("partial key '%s' matches zero certificates", lk)
+		return fmt.Errorf("%w: partial key '%s' matches zero certificates", ErrLookupKeyNotFound, lk)
 	}
 }
 
 // parseRawLookupKey takes a string in the same format that `String()` provides
-// and returns the two sub-components of the Key
+// and returns the sub-components of the Key
+//
+// Keys without a version marker are v1 keys, "v1" is also accepted as an explicit marker
 //
-// Returns an error if the key is improperly formatted
-func parseRawLookupKey(rawKey string) (string, CertType, error) {
-	var (
-		typeInd = 0
-		idInd   = 1
-	)
+// Returns an error if the key is improperly formatted or the version is unknown
+func parseRawLookupKey(rawKey string) (string, CertType, LookupKeyVersion, error) {
 	parts := strings.Split(rawKey, LookupKeySeparator)
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("unable to parse raw key '%s'", rawKey)
+	switch len(parts) {
+	case 2:
+		return parts[1], CertType(parts[0]), LookupKeyV1, nil
+	case 3:
+		switch version := LookupKeyVersion(parts[1]); version {
+		case "v1":
+			return parts[2], CertType(parts[0]), LookupKeyV1, nil
+		case LookupKeyV2:
+			return parts[2], CertType(parts[0]), version, nil
+		default:
+			return "", "", "", fmt.Errorf("unknown lookup key version '%s' in raw key '%s'", version, rawKey)
+		}
+	default:
+		return "", "", "", fmt.Errorf("unable to parse raw key '%s'", rawKey)
 	}
-	return parts[idInd], CertType(parts[typeInd]), nil
 }
 
 // ParseLookupKey takes a string in the same format that `String()` provides
@@ -113,7 +154,7 @@ func parseRawLookupKey(rawKey string) (string, CertType, error) {
 func ParseLookupKey(rawKey string) (*LookupKey, error) {
 	var err error
 	lk := &LookupKey{}
-	lk.Id, lk.Type, err = parseRawLookupKey(rawKey)
+	lk.Id, lk.Type, lk.Version, err = parseRawLookupKey(rawKey)
 	return lk, err
 }
 
@@ -140,3 +181,31 @@ func GenerateLookupKey(ident string, principals []string, certType CertType) *Lo
 		Type: certType,
 	}
 }
+
+// GenerateLookupKeyV2 creates a 64-character long sha256sum key
+// based on the CertType, public key fingerprint, Identity and Principals
+//
+// Unlike GenerateLookupKey, re-signing a different public key for the same Identity
+// results in a different key, so two machines for one user no longer collide.
+// The fingerprint is optional, leaving it empty still separates host and user certificates.
+//
+// The version marker, expanded CertType, fingerprint and Identity are joined with
+// the sorted list of Principals, separated with commas and run through sha256.Sum256()
+//
+//  Example:
+//   ident := "someUser@dev1.example.com"
+//   princs := []string{"someUser", "admin"}
+//   fprint := "SHA256:yCYTo2nP5zUcJuLWlHEJKj0jEElUE2wZvEMuh82UMQM"
+//   sampleKey := protocol.GenerateLookupKeyV2(ident, princs, protocol.UserCertificate, fprint)
+//   // sampleKey.String() => "user:v2:5b0936d4e0953418e95b79674ffc628d47a5ff6c7fc898d070a224da20dd99e7"
+func GenerateLookupKeyV2(ident string, principals []string, certType CertType, fingerprint string) *LookupKey {
+	sorted := append([]string(nil), principals...)
+	sort.Strings(sorted)
+	lookupList := append([]string{string(LookupKeyV2), string(certType.Expand()), fingerprint, ident}, sorted...)
+	lookupString := strings.Join(lookupList, ",")
+	return &LookupKey{
+		Id:      fmt.Sprintf("%x", sha256.Sum256([]byte(lookupString))),
+		Type:    certType,
+		Version: LookupKeyV2,
+	}
+}
@@ -1,6 +1,7 @@
 package protocol_test
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -10,8 +11,11 @@ import (
 )
 
 var (
-	hostTestExampleComKey = "55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d"
-	userSomeUserDevKey    = "a5ba427b532c152b3e9cded5ab36f040072f7582a455271fd26d1fc696c7ac64"
+	hostTestExampleComKey   = "55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d"
+	userSomeUserDevKey      = "a5ba427b532c152b3e9cded5ab36f040072f7582a455271fd26d1fc696c7ac64"
+	userSomeUserDevV2Key    = "5b0936d4e0953418e95b79674ffc628d47a5ff6c7fc898d070a224da20dd99e7"
+	userSomeUserDevV2NoFKey = "7a33cbe1f730ba18fd56fe13e6fe6292f337c334ff4ab4d9b558f3ce961188bb"
+	testFingerprint         = "SHA256:yCYTo2nP5zUcJuLWlHEJKj0jEElUE2wZvEMuh82UMQM"
 )
 
 func TestGenerateLookupKey(t *testing.T) {
@@ -59,10 +63,74 @@ func TestGenerateLookupKey(t *testing.T) {
 	}
 }
 
+func TestGenerateLookupKeyV2(t *testing.T) {
+	type args struct {
+		ident       string
+		principals  []string
+		certType    protocol.CertType
+		fingerprint string
+	}
+	tests := []struct {
+		name string
+		args args
+		want *protocol.LookupKey
+	}{
+		{
+			name: "valid v2 LookupKey with fingerprint",
+			args: args{
+				ident:       "someUser@dev1.example.com",
+				principals:  []string{"someUser", "admin"},
+				certType:    protocol.UserCertificate,
+				fingerprint: testFingerprint,
+			},
+			want: &protocol.LookupKey{
+				Id:      userSomeUserDevV2Key,
+				Type:    protocol.UserCertificate,
+				Version: protocol.LookupKeyV2,
+			},
+		},
+		{
+			name: "short cert types hash the same as expanded ones",
+			args: args{
+				ident:       "someUser@dev1.example.com",
+				principals:  []string{"admin", "someUser"},
+				certType:    "u",
+				fingerprint: testFingerprint,
+			},
+			want: &protocol.LookupKey{
+				Id:      userSomeUserDevV2Key,
+				Type:    "u",
+				Version: protocol.LookupKeyV2,
+			},
+		},
+		{
+			name: "valid v2 LookupKey without fingerprint",
+			args: args{
+				ident:      "someUser@dev1.example.com",
+				principals: []string{"someUser", "admin"},
+				certType:   protocol.UserCertificate,
+			},
+			want: &protocol.LookupKey{
+				Id:      userSomeUserDevV2NoFKey,
+				Type:    protocol.UserCertificate,
+				Version: protocol.LookupKeyV2,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := protocol.GenerateLookupKeyV2(tt.args.ident, tt.args.principals, tt.args.certType, tt.args.fingerprint); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GenerateLookupKeyV2() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestLookupKey_Expand(t *testing.T) {
 	type fields struct {
-		Id   string
-		Type protocol.CertType
+		Id      string
+		Type    protocol.CertType
+		Version protocol.LookupKeyVersion
 	}
 	type args struct {
 		s3Svc    s3iface.S3API
@@ -70,19 +138,20 @@ func TestLookupKey_Expand(t *testing.T) {
 		s3Prefix string
 	}
 	validBucketArgs := args{
-		s3Svc:    &protocol.MockS3Client{T: t},
-		s3Bucket: protocol.TestValidBucket,
+		s3Svc:    newTestS3(t),
+		s3Bucket: testValidBucket,
 	}
 	validHostLookupKey := &protocol.LookupKey{
 		Id:   hostTestExampleComKey,
 		Type: protocol.HostCertificate,
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    *protocol.LookupKey
-		wantErr bool
+		name         string
+		fields       fields
+		args         args
+		want         *protocol.LookupKey
+		wantErr      bool
+		wantNotFound bool
 	}{
 		{
 			name: "valid lookup key that returns a single result",
@@ -104,6 +173,21 @@ func TestLookupKey_Expand(t *testing.T) {
 			want:    validHostLookupKey,
 			wantErr: false,
 		},
+		{
+			name: "valid v2 lookup key that returns a single result",
+			fields: fields{
+				Id:      "5b0936d4",
+				Type:    "u",
+				Version: protocol.LookupKeyV2,
+			},
+			args: validBucketArgs,
+			want: &protocol.LookupKey{
+				Id:      userSomeUserDevV2Key,
+				Type:    protocol.UserCertificate,
+				Version: protocol.LookupKeyV2,
+			},
+			wantErr: false,
+		},
 		{
 			name: "valid lookup key that returns multiple keys",
 			fields: fields{
@@ -119,8 +203,9 @@ func TestLookupKey_Expand(t *testing.T) {
 				Id:   "0f739d75b44acc5b",
 				Type: protocol.UserCertificate,
 			},
-			args:    validBucketArgs,
-			wantErr: true,
+			args:         validBucketArgs,
+			wantErr:      true,
+			wantNotFound: true,
 		},
 		{
 			name: "non-existent bucket returns err from aws",
@@ -129,7 +214,7 @@ func TestLookupKey_Expand(t *testing.T) {
 				Type: protocol.UserCertificate,
 			},
 			args: args{
-				s3Svc:    &protocol.MockS3Client{T: t},
+				s3Svc:    newTestS3(t),
 				s3Bucket: "this-bucket-is-a-lie",
 			},
 			wantErr: true,
@@ -141,8 +226,8 @@ func TestLookupKey_Expand(t *testing.T) {
 				Type: protocol.HostCertificate,
 			},
 			args: args{
-				s3Svc:    &protocol.MockS3Client{T: t},
-				s3Bucket: protocol.TestValidBucket,
+				s3Svc:    newTestS3(t),
+				s3Bucket: testValidBucket,
 			},
 			wantErr: true,
 		},
@@ -150,13 +235,17 @@ func TestLookupKey_Expand(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			lk := &protocol.LookupKey{
-				Id:   tt.fields.Id,
-				Type: tt.fields.Type,
+				Id:      tt.fields.Id,
+				Type:    tt.fields.Type,
+				Version: tt.fields.Version,
 			}
 			err := lk.Expand(tt.args.s3Svc, tt.args.s3Bucket, tt.args.s3Prefix)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Expand() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if notFound := errors.Is(err, protocol.ErrLookupKeyNotFound); notFound != tt.wantNotFound {
+				t.Errorf("Expand() error = %v, want ErrLookupKeyNotFound %v", err, tt.wantNotFound)
+			}
 			if !tt.wantErr {
 				if !reflect.DeepEqual(lk, tt.want) {
 					t.Errorf("Expand() got = %v, want = %v", lk, tt.want)
@@ -257,8 +346,9 @@ func TestLookupKey_UnmarshalJSON(t *testing.T) {
 
 func TestLookupKey_String(t *testing.T) {
 	type fields struct {
-		Id   string
-		Type protocol.CertType
+		Id      string
+		Type    protocol.CertType
+		Version protocol.LookupKeyVersion
 	}
 	tests := []struct {
 		name   string
@@ -281,12 +371,22 @@ func TestLookupKey_String(t *testing.T) {
 			},
 			want: "user:a5ba427b532c152b3e9cded5ab36f040072f7582a455271fd26d1fc696c7ac64",
 		},
+		{
+			name: "User v2 LookupKey",
+			fields: fields{
+				Id:      userSomeUserDevV2Key,
+				Type:    protocol.UserCertificate,
+				Version: protocol.LookupKeyV2,
+			},
+			want: "user:v2:5b0936d4e0953418e95b79674ffc628d47a5ff6c7fc898d070a224da20dd99e7",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			lk := &protocol.LookupKey{
-				Id:   tt.fields.Id,
-				Type: tt.fields.Type,
+				Id:      tt.fields.Id,
+				Type:    tt.fields.Type,
+				Version: tt.fields.Version,
 			}
 			if got := lk.String(); got != tt.want {
 				t.Errorf("String() = %v, want %v", got, tt.want)
@@ -332,6 +432,31 @@ func TestParseLookupKey(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "correctly parses v2 LookupKey",
+			args: args{rawKey: "user:v2:5b0936d4"},
+			want: &protocol.LookupKey{
+				Id:      "5b0936d4",
+				Type:    protocol.UserCertificate,
+				Version: protocol.LookupKeyV2,
+			},
+			wantErr: false,
+		},
+		{
+			name: "correctly parses explicit v1 LookupKey",
+			args: args{rawKey: "host:v1:55e8182ec4413d51"},
+			want: &protocol.LookupKey{
+				Id:   "55e8182ec4413d51",
+				Type: protocol.HostCertificate,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "returns an error if the version is unknown",
+			args:    args{rawKey: "host:v9:55e8182ec4413d51"},
+			want:    &protocol.LookupKey{},
+			wantErr: true,
+		},
 		{
 			name:    "returns an error if the Key is invalid",
 			args:    args{rawKey: "hosts/55e8182ec4413d51"},
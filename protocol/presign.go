@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// DefaultCertificateURLTTL is how long presigned Certificate URLs stay valid when no TTL is given
+const DefaultCertificateURLTTL = 5 * time.Minute
+
+// ErrCertificateURLExpired is returned by DownloadCertificate once the presigned URL has expired
+var ErrCertificateURLExpired = errors.New("certificate url has expired")
+
+// s3MetadataHeaderPrefix is the (canonicalized) prefix of S3 user metadata response headers
+const s3MetadataHeaderPrefix = "X-Amz-Meta-"
+
+// PresignCertificateURL returns a GET URL for exactly the object at c.ObjectKey(prefix),
+// valid for ttl (DefaultCertificateURLTTL if <= 0)
+//
+// The URL carries the permissions of the credentials used by s3Svc,
+// so clients don't need s3:GetObject on the bucket themselves
+func PresignCertificateURL(s3Svc s3iface.S3API, s3Bucket string, prefix string, c *SignedCertificateS3Object, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = DefaultCertificateURLTTL
+	}
+	req, _ := s3Svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s3Bucket),
+		Key:    aws.String(c.ObjectKey(prefix)),
+	})
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return "", fmt.Errorf("unable to presign certificate url: %w", err)
+	}
+	return url, nil
+}
+
+// SetCertificateURL presigns a URL for c (see PresignCertificateURL) and records it in the response
+func (r *RequestSSHCertLambdaResponse) SetCertificateURL(s3Svc s3iface.S3API, s3Bucket string, prefix string, c *SignedCertificateS3Object, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultCertificateURLTTL
+	}
+	expiresOn := time.Now().Add(ttl).UTC()
+	url, err := PresignCertificateURL(s3Svc, s3Bucket, prefix, c, ttl)
+	if err != nil {
+		return err
+	}
+	r.CertificateURL, r.CertificateURLExpiresOn = url, &expiresOn
+	return nil
+}
+
+// DownloadCertificate fetches the Certificate from the response's presigned CertificateURL
+// with httpClient (http.DefaultClient if nil), see FetchCertificateURL
+//
+// The Certificate must belong to the response's LookupKey
+func (r *RequestSSHCertLambdaResponse) DownloadCertificate(httpClient *http.Client, opts *LoadOptions) (*SignedCertificateS3Object, error) {
+	if r.CertificateURL == "" {
+		return nil, errors.New("response has no certificate url")
+	}
+	if r.CertificateURLExpiresOn != nil && time.Now().After(*r.CertificateURLExpiresOn) {
+		return nil, fmt.Errorf("%w: expired on %s", ErrCertificateURLExpired, r.CertificateURLExpiresOn.Format(time.RFC3339))
+	}
+	cert, err := FetchCertificateURL(httpClient, r.CertificateURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	if lk := cert.LookupKey().String(); r.LookupKey != "" && lk != r.LookupKey {
+		return nil, fmt.Errorf("downloaded certificate %s does not match lookup key %s", lk, r.LookupKey)
+	}
+	return cert, nil
+}
+
+// FetchCertificateURL downloads and parses a SignedCertificateS3Object from a (presigned) URL
+// with httpClient (http.DefaultClient if nil)
+//
+// The download is checked against opts (DefaultLoadOptions if nil) like any other object,
+// using the Content-Type and S3 user metadata headers of the response
+func FetchCertificateURL(httpClient *http.Client, url string, opts *LoadOptions) (*SignedCertificateS3Object, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download certificate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download certificate: %s", resp.Status)
+	}
+	body, err := readObjectBody("certificate", resp.Body, resp.ContentLength, resp.Header.Get("Content-Type"), headerMetadata(resp.Header), opts.orDefault())
+	if err != nil {
+		return nil, err
+	}
+	cert := &SignedCertificateS3Object{}
+	if err = json.Unmarshal(body, cert); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// headerMetadata collects the S3 user metadata sent as X-Amz-Meta-* headers
+func headerMetadata(header http.Header) map[string]*string {
+	metadata := map[string]*string{}
+	for name := range header {
+		if key := strings.TrimPrefix(name, s3MetadataHeaderPrefix); key != name {
+			metadata[key] = aws.String(header.Get(name))
+		}
+	}
+	return metadata
+}
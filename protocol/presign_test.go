@@ -0,0 +1,111 @@
+package protocol_test
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestRequestSSHCertLambdaResponse_DownloadCertificate(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	srv := &fakeaws.Server{S3: s3Svc}
+	if _, err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+
+	issuedOn := time.Now().UTC().Truncate(time.Second)
+	cert, _ := newTestCertificate(t, protocol.UserCertificate, "alice", []string{"alice"}, issuedOn, time.Hour)
+	if err := cert.SaveObject(s3Svc, testValidBucket, ""); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := newTestCertificate(t, protocol.UserCertificate, "bob", []string{"bob"}, issuedOn, time.Hour)
+
+	resp := &protocol.RequestSSHCertLambdaResponse{LookupKey: cert.LookupKey().String()}
+	if err := resp.SetCertificateURL(s3Svc, testValidBucket, "", cert, time.Minute); err != nil {
+		t.Fatalf("SetCertificateURL() error = %v", err)
+	}
+	u, err := url.Parse(resp.CertificateURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/" + testValidBucket + "/" + cert.ObjectKey(""); u.Path != want {
+		t.Errorf("SetCertificateURL() path = %v, want %v", u.Path, want)
+	}
+	if u.Query().Get("X-Amz-Expires") != "60" {
+		t.Errorf("SetCertificateURL() X-Amz-Expires = %v, want 60", u.Query().Get("X-Amz-Expires"))
+	}
+
+	got, err := resp.DownloadCertificate(nil, nil)
+	if err != nil {
+		t.Fatalf("DownloadCertificate() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.RawSignedCertificate, cert.RawSignedCertificate) || got.Identity != cert.Identity {
+		t.Errorf("DownloadCertificate() got = %v, want %v", got, cert)
+	}
+
+	strict := &protocol.LoadOptions{MaxSize: protocol.DefaultMaxObjectSize, CheckContentType: true, RequireChecksum: true}
+	if _, err := resp.DownloadCertificate(nil, strict); err != nil {
+		t.Errorf("DownloadCertificate() with every check error = %v", err)
+	}
+
+	mismatched := *resp
+	mismatched.LookupKey = other.LookupKey().String()
+	if _, err := mismatched.DownloadCertificate(nil, nil); err == nil {
+		t.Errorf("DownloadCertificate() with another LookupKey error = nil, want error")
+	}
+
+	expired := *resp
+	expiresOn := time.Now().Add(-time.Second)
+	expired.CertificateURLExpiresOn = &expiresOn
+	if _, err := expired.DownloadCertificate(nil, nil); !errors.Is(err, protocol.ErrCertificateURLExpired) {
+		t.Errorf("DownloadCertificate() error = %v, want %v", err, protocol.ErrCertificateURLExpired)
+	}
+
+	if _, err := (&protocol.RequestSSHCertLambdaResponse{}).DownloadCertificate(nil, nil); err == nil {
+		t.Errorf("DownloadCertificate() without a url error = nil, want error")
+	}
+
+	missing := &protocol.RequestSSHCertLambdaResponse{}
+	if err := missing.SetCertificateURL(s3Svc, testValidBucket, "", other, 0); err != nil {
+		t.Fatalf("SetCertificateURL() error = %v", err)
+	}
+	if _, err := missing.DownloadCertificate(nil, nil); err == nil {
+		t.Errorf("DownloadCertificate() of a missing object error = nil, want error")
+	}
+
+	_, err = s3Svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(testValidBucket),
+		Key:         aws.String(cert.ObjectKey("")),
+		Body:        strings.NewReader(`{"identity":"alice"}`),
+		ContentType: aws.String("text/plain"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resp.DownloadCertificate(nil, &protocol.LoadOptions{CheckContentType: true}); !errors.Is(err, protocol.ErrUnexpectedContentType) {
+		t.Errorf("DownloadCertificate() error = %v, want %v", err, protocol.ErrUnexpectedContentType)
+	}
+
+	_, err = s3Svc.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String(testValidBucket),
+		Key:      aws.String(cert.ObjectKey("")),
+		Body:     strings.NewReader(`{"identity":"mallory"}`),
+		Metadata: map[string]*string{protocol.ChecksumMetadataKey: aws.String("0000")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resp.DownloadCertificate(nil, nil); !errors.Is(err, protocol.ErrChecksumMismatch) {
+		t.Errorf("DownloadCertificate() error = %v, want %v", err, protocol.ErrChecksumMismatch)
+	}
+}
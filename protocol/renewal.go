@@ -0,0 +1,151 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultProofMaxAge is how old a ProofOfPossession may be before it is rejected
+const DefaultProofMaxAge = 5 * time.Minute
+
+// proofOfPossessionMagic is prepended to every signed renewal challenge
+// so the signature can't be replayed as a signature over something else
+const proofOfPossessionMagic = "schism-renewal-v1"
+
+// RenewSSHCertLambdaPayload asks the CA to re-sign the public key of an existing Certificate
+//
+// Identity, Principals, CertificateOptions and the PublicKey are copied from the
+// Certificate saved under LookupKey, only the validity window is taken from the payload
+type RenewSSHCertLambdaPayload struct {
+	// Full LookupKey of the Certificate being renewed
+	LookupKey string `json:"lookup_key"`
+	// Length of time the renewed Certificate will be valid for,
+	// 0 reuses the ValidityInterval of the previous Certificate.
+	ValidityInterval time.Duration `json:"validity_interval,omitempty"`
+	// Optional start of the validity window, see RequestSSHCertLambdaPayload
+	ValidAfter *time.Time `json:"valid_after,omitempty"`
+	// Optional end of the validity window, see RequestSSHCertLambdaPayload
+	ValidBefore *time.Time `json:"valid_before,omitempty"`
+	// Signature proving the caller holds the private key, required if the CA says so
+	ProofOfPossession *ProofOfPossession `json:"proof_of_possession,omitempty"`
+	// Encoding used when marshaling, also passed on to the RequestSSHCertLambdaPayload
+	SchemaVersion SchemaVersion `json:"schema_version,omitempty"`
+}
+
+// ProofOfPossession is a signature over the renewal challenge made with the certified key
+type ProofOfPossession struct {
+	// When the challenge was signed, limits how long the proof can be replayed
+	SignedOn time.Time `json:"signed_on"`
+	// ssh wire format of the ssh.Signature
+	Signature []byte `json:"signature"`
+}
+
+// renewalChallenge returns the bytes signed for a ProofOfPossession
+//
+//  Format:
+//   schism-renewal-v1\n{lookupKey}\n{signedOn RFC3339Nano}
+func renewalChallenge(lookupKey string, signedOn time.Time) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s", proofOfPossessionMagic, lookupKey, signedOn.UTC().Format(time.RFC3339Nano)))
+}
+
+// NewProofOfPossession signs the renewal challenge for lookupKey with signer
+func NewProofOfPossession(signer ssh.Signer, lookupKey string, now time.Time) (*ProofOfPossession, error) {
+	sig, err := signer.Sign(nil, renewalChallenge(lookupKey, now))
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign renewal challenge: %w", err)
+	}
+	return &ProofOfPossession{SignedOn: now.UTC(), Signature: ssh.Marshal(sig)}, nil
+}
+
+// Verify checks the proof was signed by pubKey for lookupKey no more than maxAge before now
+//
+// A maxAge <= 0 means DefaultProofMaxAge
+func (p *ProofOfPossession) Verify(pubKey ssh.PublicKey, lookupKey string, now time.Time, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		maxAge = DefaultProofMaxAge
+	}
+	if age := now.Sub(p.SignedOn); age > maxAge || age < -maxAge {
+		return fmt.Errorf("proof of possession signed at %s is outside the allowed %s", p.SignedOn.Format(time.RFC3339), maxAge)
+	}
+	sig := &ssh.Signature{}
+	if err := ssh.Unmarshal(p.Signature, sig); err != nil {
+		return fmt.Errorf("unable to parse proof of possession signature: %w", err)
+	}
+	if err := pubKey.Verify(renewalChallenge(lookupKey, p.SignedOn), sig); err != nil {
+		return fmt.Errorf("proof of possession signature is invalid: %w", err)
+	}
+	return nil
+}
+
+// LoadPrevious loads the Certificate being renewed
+//
+// Returns a ProtocolError with ErrCodeNotFound if there is no Certificate under LookupKey
+func (p *RenewSSHCertLambdaPayload) LoadPrevious(s3Svc s3iface.S3API, s3Bucket string, prefix string) (*SignedCertificateS3Object, error) {
+	lk, err := ParseLookupKey(p.LookupKey)
+	if err != nil {
+		return nil, NewProtocolError(ErrCodeInvalidRequest, "%s", err)
+	}
+	previous := &SignedCertificateS3Object{}
+	if err = previous.LoadObject(s3Svc, s3Bucket, lk.ObjectKey(prefix)); isNotFound(err) {
+		return nil, NewProtocolError(ErrCodeNotFound, "no certificate found for %s", lk)
+	} else if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// RenewalRequest builds the RequestSSHCertLambdaPayload to sign for this renewal
+//
+// When requireProof is set the ProofOfPossession must verify against the previous
+// Certificate's public key, see ProofOfPossession.Verify.
+// Returns a ProtocolError if the previous Certificate doesn't match the LookupKey,
+// its key can't be recovered or the proof is missing or invalid
+func (p *RenewSSHCertLambdaPayload) RenewalRequest(
+	previous *SignedCertificateS3Object,
+	requireProof bool,
+	now time.Time,
+	maxProofAge time.Duration,
+) (*RequestSSHCertLambdaPayload, error) {
+	if lk := previous.LookupKey().String(); lk != p.LookupKey {
+		return nil, NewProtocolError(ErrCodeInvalidRequest, "certificate %s does not match lookup key %s", lk, p.LookupKey)
+	}
+	cert, err := previous.Certificate()
+	if err != nil {
+		return nil, AsProtocolError(err)
+	}
+	if previous.PublicKeyFingerprint != "" && previous.PublicKeyFingerprint != ssh.FingerprintSHA256(cert.Key) {
+		return nil, NewProtocolError(ErrCodeInternal, "certificate %s does not match its recorded fingerprint", p.LookupKey)
+	}
+	if requireProof {
+		if p.ProofOfPossession == nil {
+			return nil, NewProtocolError(ErrCodeAccessDenied, "proof of possession is required to renew %s", p.LookupKey)
+		}
+		if err = p.ProofOfPossession.Verify(cert.Key, p.LookupKey, now, maxProofAge); err != nil {
+			return nil, NewProtocolError(ErrCodeAccessDenied, "%s", err)
+		}
+	}
+	interval := p.ValidityInterval
+	if interval == 0 {
+		interval = previous.ValidityInterval
+	}
+	return &RequestSSHCertLambdaPayload{
+		CertificateType:    previous.CertificateType,
+		Identity:           previous.Identity,
+		Principals:         append([]string(nil), previous.Principals...),
+		ValidityInterval:   interval,
+		ValidAfter:         p.ValidAfter,
+		ValidBefore:        p.ValidBefore,
+		CertificateOptions: previous.CertificateOptions,
+		PublicKey:          string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(cert.Key))),
+		SchemaVersion:      p.SchemaVersion,
+	}, nil
+}
+
+// LinkRenewal records previous as the issuance this Certificate renews, see RenewedFrom
+func (c *SignedCertificateS3Object) LinkRenewal(previous *SignedCertificateS3Object, prefix string) {
+	c.RenewedFrom = previous.HistoryObjectKey(prefix)
+}
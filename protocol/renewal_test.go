@@ -0,0 +1,153 @@
+package protocol_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestProofOfPossession_Verify(t *testing.T) {
+	now := time.Date(2022, 5, 22, 12, 0, 0, 0, time.UTC)
+	signer := newTestSigner(t)
+	proof, err := protocol.NewProofOfPossession(signer, "user:v2:abc", now)
+	if err != nil {
+		t.Fatalf("NewProofOfPossession() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		pubKey    ssh.PublicKey
+		lookupKey string
+		now       time.Time
+		wantErr   bool
+	}{
+		{name: "valid", pubKey: signer.PublicKey(), lookupKey: "user:v2:abc", now: now.Add(time.Minute)},
+		{name: "other key", pubKey: newTestSigner(t).PublicKey(), lookupKey: "user:v2:abc", now: now, wantErr: true},
+		{name: "other lookup key", pubKey: signer.PublicKey(), lookupKey: "user:v2:abd", now: now, wantErr: true},
+		{name: "too old", pubKey: signer.PublicKey(), lookupKey: "user:v2:abc", now: now.Add(time.Hour), wantErr: true},
+		{name: "from the future", pubKey: signer.PublicKey(), lookupKey: "user:v2:abc", now: now.Add(-time.Hour), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := proof.Verify(tt.pubKey, tt.lookupKey, tt.now, 0); (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenewSSHCertLambdaPayload_RenewalRequest(t *testing.T) {
+	issuedOn := time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC)
+	now := issuedOn.Add(20 * time.Hour)
+	previous, signer := newTestCertificate(t, protocol.UserCertificate, "alice", []string{"alice", "admin"}, issuedOn, 24*time.Hour)
+	previous.CertificateOptions = &protocol.CertificateOptions{Extensions: protocol.DefaultExtensions()}
+	lookupKey := previous.LookupKey().String()
+	proof, _ := protocol.NewProofOfPossession(signer, lookupKey, now)
+	otherProof, _ := protocol.NewProofOfPossession(newTestSigner(t), lookupKey, now)
+	publicKey := string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+	tests := []struct {
+		name         string
+		payload      *protocol.RenewSSHCertLambdaPayload
+		requireProof bool
+		want         *protocol.RequestSSHCertLambdaPayload
+		wantCode     protocol.ErrorCode
+	}{
+		{
+			name:    "copies the previous request",
+			payload: &protocol.RenewSSHCertLambdaPayload{LookupKey: lookupKey},
+			want: &protocol.RequestSSHCertLambdaPayload{
+				CertificateType:    protocol.UserCertificate,
+				Identity:           "alice",
+				Principals:         []string{"alice", "admin"},
+				ValidityInterval:   24 * time.Hour,
+				CertificateOptions: previous.CertificateOptions,
+				PublicKey:          publicKey,
+			},
+		},
+		{
+			name:         "new interval with proof",
+			payload:      &protocol.RenewSSHCertLambdaPayload{LookupKey: lookupKey, ValidityInterval: time.Hour, ProofOfPossession: proof},
+			requireProof: true,
+			want: &protocol.RequestSSHCertLambdaPayload{
+				CertificateType:    protocol.UserCertificate,
+				Identity:           "alice",
+				Principals:         []string{"alice", "admin"},
+				ValidityInterval:   time.Hour,
+				CertificateOptions: previous.CertificateOptions,
+				PublicKey:          publicKey,
+			},
+		},
+		{
+			name:         "missing proof",
+			payload:      &protocol.RenewSSHCertLambdaPayload{LookupKey: lookupKey},
+			requireProof: true,
+			wantCode:     protocol.ErrCodeAccessDenied,
+		},
+		{
+			name:         "proof from another key",
+			payload:      &protocol.RenewSSHCertLambdaPayload{LookupKey: lookupKey, ProofOfPossession: otherProof},
+			requireProof: true,
+			wantCode:     protocol.ErrCodeAccessDenied,
+		},
+		{
+			name:     "lookup key mismatch",
+			payload:  &protocol.RenewSSHCertLambdaPayload{LookupKey: "user:v2:0000"},
+			wantCode: protocol.ErrCodeInvalidRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.payload.RenewalRequest(previous, tt.requireProof, now, 0)
+			if tt.wantCode != "" {
+				var perr *protocol.ProtocolError
+				if !errors.As(err, &perr) || perr.Code != tt.wantCode {
+					t.Fatalf("RenewalRequest() error = %v, want code %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenewalRequest() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RenewalRequest() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenewSSHCertLambdaPayload_LoadPrevious(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	issuedOn := time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC)
+	previous, _ := newTestCertificate(t, protocol.HostCertificate, "test.example.com", []string{"test.example.com"}, issuedOn, time.Hour)
+	if err := previous.SaveObject(s3Svc, testValidBucket, prefix); err != nil {
+		t.Fatalf("SaveObject() error = %v", err)
+	}
+
+	payload := &protocol.RenewSSHCertLambdaPayload{LookupKey: previous.LookupKey().String()}
+	got, err := payload.LoadPrevious(s3Svc, testValidBucket, prefix)
+	if err != nil {
+		t.Fatalf("LoadPrevious() error = %v", err)
+	}
+	if got.HistoryObjectKey(prefix) != previous.HistoryObjectKey(prefix) {
+		t.Errorf("LoadPrevious() got = %+v, want %+v", got, previous)
+	}
+
+	renewed := &protocol.SignedCertificateS3Object{IssuedOn: issuedOn.Add(time.Hour)}
+	renewed.LinkRenewal(got, prefix)
+	if renewed.RenewedFrom != previous.HistoryObjectKey(prefix) {
+		t.Errorf("LinkRenewal() RenewedFrom = %v, want %v", renewed.RenewedFrom, previous.HistoryObjectKey(prefix))
+	}
+
+	missing := &protocol.RenewSSHCertLambdaPayload{LookupKey: "host:v2:0000"}
+	var perr *protocol.ProtocolError
+	if _, err = missing.LoadPrevious(s3Svc, testValidBucket, prefix); !errors.As(err, &perr) || perr.Code != protocol.ErrCodeNotFound {
+		t.Errorf("LoadPrevious() error = %v, want code %v", err, protocol.ErrCodeNotFound)
+	}
+}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// DefaultReplicaTimeout is how long a single replica gets to answer when ReplicaSet.Timeout isn't set
+const DefaultReplicaTimeout = 5 * time.Second
+
+// Replica is one copy of a Schism store, usually a bucket replicated to another region
+type Replica struct {
+	// Region the bucket lives in, informational, S3Svc must already be configured for it
+	Region string
+	// Name of the bucket in that region
+	Bucket string
+	// S3 client for the region, e.g. commonLib.S3Client(Region)
+	S3Svc s3iface.S3API
+}
+
+// String returns "region/bucket"
+func (r *Replica) String() string {
+	return fmt.Sprintf("%s/%s", r.Region, r.Bucket)
+}
+
+// ReplicaSet reads from an ordered list of Replicas, failing over to the next one
+// when a replica errors or doesn't answer in time
+//
+//  Example:
+//   replicas := &protocol.ReplicaSet{Replicas: []protocol.Replica{
+//   	{Region: "us-east-1", Bucket: "schism", S3Svc: commonLib.S3Client("us-east-1")},
+//   	{Region: "us-west-2", Bucket: "schism-replica", S3Svc: commonLib.S3Client("us-west-2")},
+//   }}
+//   served, err := replicas.LoadObject(key, cert)
+type ReplicaSet struct {
+	// Replicas in order of preference
+	Replicas []Replica
+	// How long each replica gets to answer, DefaultReplicaTimeout if <= 0
+	Timeout time.Duration
+}
+
+// ReplicaError is returned when every replica failed, Errors holds one error per replica in order
+type ReplicaError struct {
+	Errors []error
+}
+
+func (e *ReplicaError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("all %d replicas failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the error from the preferred replica
+func (e *ReplicaError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[0]
+}
+
+// LoadObject loads s3ObjectKey into obj from the first replica that can serve it
+//
+// Returns the Replica that served the object.
+// If the object is missing from every replica the preferred replica's not found error is returned,
+// otherwise a *ReplicaError
+func (rs *ReplicaSet) LoadObject(s3ObjectKey string, obj S3Object) (*Replica, error) {
+	return rs.try(func(s3Svc s3iface.S3API, s3Bucket string) error {
+		return obj.LoadObject(s3Svc, s3Bucket, s3ObjectKey)
+	})
+}
+
+// Expand expands lk against the first replica that answers, see `lk.Expand()`
+//
+// Returns the Replica that served the listing, errors are reported the same way as LoadObject,
+// a key matching nothing in every replica returns the preferred replica's ErrLookupKeyNotFound error
+func (rs *ReplicaSet) Expand(lk *LookupKey, prefix string) (*Replica, error) {
+	return rs.try(func(s3Svc s3iface.S3API, s3Bucket string) error {
+		expanded := *lk
+		if err := expanded.Expand(s3Svc, s3Bucket, prefix); err != nil {
+			return err
+		}
+		*lk = expanded
+		return nil
+	})
+}
+
+// try runs fn against every replica in order until one succeeds
+func (rs *ReplicaSet) try(fn func(s3Svc s3iface.S3API, s3Bucket string) error) (*Replica, error) {
+	if len(rs.Replicas) == 0 {
+		return nil, fmt.Errorf("no replicas configured")
+	}
+	timeout := rs.Timeout
+	if timeout <= 0 {
+		timeout = DefaultReplicaTimeout
+	}
+	var (
+		errs        = make([]error, 0, len(rs.Replicas))
+		firstErr    error
+		allNotFound = true
+	)
+	for i := range rs.Replicas {
+		replica := &rs.Replicas[i]
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := fn(&timeoutS3{S3API: replica.S3Svc, ctx: ctx}, replica.Bucket)
+		cancel()
+		if err == nil {
+			return replica, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		allNotFound = allNotFound && isNotFound(err)
+		errs = append(errs, fmt.Errorf("%s: %w", replica, err))
+	}
+	if allNotFound {
+		return nil, firstErr
+	}
+	return nil, &ReplicaError{Errors: errs}
+}
+
+// timeoutS3 binds the read calls used by LoadObject and Expand to ctx
+type timeoutS3 struct {
+	s3iface.S3API
+	ctx context.Context
+}
+
+func (t *timeoutS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return t.S3API.GetObjectWithContext(t.ctx, input)
+}
+
+func (t *timeoutS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return t.S3API.HeadObjectWithContext(t.ctx, input)
+}
+
+func (t *timeoutS3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	return t.S3API.ListObjectsV2WithContext(t.ctx, input)
+}
+
+func (t *timeoutS3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	return t.S3API.ListObjectsV2PagesWithContext(t.ctx, input, fn)
+}
@@ -0,0 +1,116 @@
+package protocol_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// stalledS3 never answers, requests only return once their context is done
+type stalledS3 struct {
+	*fakeaws.S3
+}
+
+func (s *stalledS3) GetObjectWithContext(ctx aws.Context, _ *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	<-ctx.Done()
+	return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+}
+
+func (s *stalledS3) ListObjectsV2WithContext(ctx aws.Context, _ *s3.ListObjectsV2Input, _ ...request.Option) (*s3.ListObjectsV2Output, error) {
+	<-ctx.Done()
+	return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+}
+
+// wrappingS3 wraps the errors of GetObject, like a client with extra instrumentation would
+type wrappingS3 struct {
+	*fakeaws.S3
+}
+
+func (s *wrappingS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	out, err := s.S3.GetObjectWithContext(ctx, input, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("instrumented GetObject: %w", err)
+	}
+	return out, nil
+}
+
+func TestReplicaSet(t *testing.T) {
+	primary := &stalledS3{S3: fakeaws.NewS3(testValidBucket)}
+	secondary := newTestS3(t)
+	empty := fakeaws.NewS3(testValidBucket)
+	certKey := protocol.S3CertStoragePrefix + "host:55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d.json"
+
+	replicas := &protocol.ReplicaSet{
+		Replicas: []protocol.Replica{
+			{Region: "us-east-1", Bucket: testValidBucket, S3Svc: primary},
+			{Region: "us-west-2", Bucket: "missing-bucket", S3Svc: secondary},
+			{Region: "eu-west-1", Bucket: testValidBucket, S3Svc: secondary},
+		},
+		Timeout: 10 * time.Millisecond,
+	}
+
+	t.Run("LoadObject fails over", func(t *testing.T) {
+		cert := &protocol.SignedCertificateS3Object{}
+		served, err := replicas.LoadObject(certKey, cert)
+		if err != nil {
+			t.Fatalf("LoadObject() error = %v", err)
+		}
+		if served.Region != "eu-west-1" || cert.Identity != "test.example.com" {
+			t.Errorf("LoadObject() served by %v with %+v", served, cert)
+		}
+	})
+
+	t.Run("Expand fails over", func(t *testing.T) {
+		lk := &protocol.LookupKey{Id: "55e8182e", Type: "h"}
+		served, err := replicas.Expand(lk, "")
+		if err != nil {
+			t.Fatalf("Expand() error = %v", err)
+		}
+		if served.Region != "eu-west-1" || lk.String() != "host:55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d" {
+			t.Errorf("Expand() served by %v with %v", served, lk)
+		}
+	})
+
+	t.Run("every replica failing", func(t *testing.T) {
+		_, err := replicas.LoadObject("missing.json", &protocol.SignedCertificateS3Object{})
+		var replicaErr *protocol.ReplicaError
+		if !errors.As(err, &replicaErr) || len(replicaErr.Errors) != 3 {
+			t.Errorf("LoadObject() error = %v, want a ReplicaError for 3 replicas", err)
+		}
+	})
+
+	t.Run("missing everywhere", func(t *testing.T) {
+		notFound := &protocol.ReplicaSet{Replicas: []protocol.Replica{
+			{Region: "us-east-1", Bucket: testValidBucket, S3Svc: empty},
+			{Region: "us-west-2", Bucket: testValidBucket, S3Svc: secondary},
+		}}
+		_, err := notFound.LoadObject("missing.json", &protocol.SignedCertificateS3Object{})
+		if aErr, ok := err.(awserr.Error); !ok || aErr.Code() != s3.ErrCodeNoSuchKey {
+			t.Errorf("LoadObject() error = %v, want %v", err, s3.ErrCodeNoSuchKey)
+		}
+		if _, err = notFound.Expand(&protocol.LookupKey{Id: "0f739d75b44acc5b", Type: protocol.UserCertificate}, ""); !errors.Is(err, protocol.ErrLookupKeyNotFound) {
+			t.Errorf("Expand() error = %v, want %v", err, protocol.ErrLookupKeyNotFound)
+		}
+	})
+
+	t.Run("wrapped not found errors", func(t *testing.T) {
+		notFound := &protocol.ReplicaSet{Replicas: []protocol.Replica{
+			{Region: "us-east-1", Bucket: testValidBucket, S3Svc: &wrappingS3{S3: empty}},
+			{Region: "us-west-2", Bucket: testValidBucket, S3Svc: empty},
+		}}
+		_, err := notFound.LoadObject("missing.json", &protocol.SignedCertificateS3Object{})
+		var aErr awserr.Error
+		if !errors.As(err, &aErr) || aErr.Code() != s3.ErrCodeNoSuchKey {
+			t.Errorf("LoadObject() error = %v, want %v", err, s3.ErrCodeNoSuchKey)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3RequestStatusPrefix The subprefix for storing the status of asynchronous requests
+//
+//	Full Object path will follow this template
+//	 {profile.S3Prefix}{S3RequestStatusPrefix}{RequestId}.json
+const S3RequestStatusPrefix = "Requests/"
+
+// RequestStatus is the state of an asynchronous certificate request
+type RequestStatus string
+
+// Valid options for RequestStatus
+const (
+	// Waiting for approval or for the CA to get to it
+	StatusPending RequestStatus = "pending"
+	// Approved but not signed yet
+	StatusApproved RequestStatus = "approved"
+	// Signed, the Certificate can be loaded
+	StatusIssued RequestStatus = "issued"
+	// Refused by an approver or policy
+	StatusDenied RequestStatus = "denied"
+	// The CA ran into an error
+	StatusFailed RequestStatus = "failed"
+)
+
+// Done reports whether the status is final
+func (rs RequestStatus) Done() bool {
+	return rs == StatusIssued || rs == StatusDenied || rs == StatusFailed
+}
+
+// NewRequestId returns a random 32-character request id
+func NewRequestId() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("unable to generate request id: %w", err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// RequestStatusS3Object tracks an asynchronous certificate request from submission to issuance
+type RequestStatusS3Object struct {
+	// Id returned to the client in RequestSSHCertLambdaResponse.RequestId
+	RequestId string `json:"request_id"`
+	// Current state of the request
+	Status RequestStatus `json:"status"`
+	// LookupKey.String() of the requested Certificate
+	LookupKey string `json:"lookup_key"`
+	// S3 ObjectKey of the issued Certificate, set once the Status is StatusIssued
+	CertificateObjectKey string `json:"certificate_object_key,omitempty"`
+	// Why the request was denied or failed
+	Error *ProtocolError `json:"error,omitempty"`
+	// Timestamp of the last status change
+	UpdatedOn time.Time `json:"updated_on"`
+}
+
+// ObjectKey given a prefix, return the key the status is saved under
+//
+//	Format:
+//	 {prefix}{S3RequestStatusPrefix}{RequestId}.json
+func (c *RequestStatusS3Object) ObjectKey(prefix string) string {
+	return fmt.Sprintf("%s%s%s.json", prefix, S3RequestStatusPrefix, c.RequestId)
+}
+
+// LoadObject loads an object from the given s3://{s3Bucket}/{s3ObjectKey} and un-marshals it into a RequestStatusS3Object
+func (c *RequestStatusS3Object) LoadObject(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string) error {
+	body, err := rawLoadS3Object(s3Svc, s3Bucket, s3ObjectKey, nil)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, c); err != nil {
+		return fmt.Errorf("unable to unmarshal object (%s): %w", s3ObjectKey, err)
+	}
+	return nil
+}
+
+// SaveObject saves the status under ObjectKey, replacing the previous status
+func (c *RequestStatusS3Object) SaveObject(s3Svc s3iface.S3API, s3Bucket string, prefix string) error {
+	return rawSaveS3Object(s3Svc, s3Bucket, c.ObjectKey(prefix), c)
+}
+
+// WaitOptions controls how WaitForCertificate polls S3
+type WaitOptions struct {
+	// Delay before the second poll, doubled after every poll. Defaults to 1s
+	InitialInterval time.Duration
+	// Upper bound for the delay between polls. Defaults to 30s
+	MaxInterval time.Duration
+	// When waiting without a RequestId, Certificates minted before this time are ignored
+	// so a previous issuance under the same LookupKey isn't mistaken for the new one
+	IssuedAfter time.Time
+}
+
+// WaitForCertificate polls S3 with exponential backoff until the Certificate
+// for the response appears, the request fails, or ctx is done.
+//
+// With a RequestId the RequestStatusS3Object is followed to the issued Certificate,
+// without one the Certificate is loaded from the LookupKey's ObjectKey.
+//
+// Returns a ProtocolError if the request was denied or failed
+func WaitForCertificate(
+	ctx context.Context,
+	s3Svc s3iface.S3API,
+	s3Bucket string,
+	prefix string,
+	resp *RequestSSHCertLambdaResponse,
+	opts *WaitOptions,
+) (*SignedCertificateS3Object, error) {
+	if opts == nil {
+		opts = &WaitOptions{}
+	}
+	interval, maxInterval := opts.InitialInterval, opts.MaxInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if maxInterval <= 0 {
+		maxInterval = 30 * time.Second
+	}
+	lk, err := ParseLookupKey(resp.LookupKey)
+	if err != nil && resp.RequestId == "" {
+		return nil, err
+	}
+	for {
+		var cert *SignedCertificateS3Object
+		if resp.RequestId != "" {
+			cert, err = pollRequestStatus(s3Svc, s3Bucket, prefix, resp.RequestId)
+		} else {
+			cert, err = pollCertificate(s3Svc, s3Bucket, lk.ObjectKey(prefix), opts.IssuedAfter)
+		}
+		if cert != nil || err != nil {
+			return cert, err
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// pollRequestStatus returns the Certificate once the request has been issued,
+// nil while it is still in progress or an error if it failed
+func pollRequestStatus(s3Svc s3iface.S3API, s3Bucket string, prefix string, requestId string) (*SignedCertificateS3Object, error) {
+	status := &RequestStatusS3Object{RequestId: requestId}
+	if err := status.LoadObject(s3Svc, s3Bucket, status.ObjectKey(prefix)); isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	switch status.Status {
+	case StatusIssued:
+		objectKey := status.CertificateObjectKey
+		if objectKey == "" {
+			lk, err := ParseLookupKey(status.LookupKey)
+			if err != nil {
+				return nil, err
+			}
+			objectKey = lk.ObjectKey(prefix)
+		}
+		cert := &SignedCertificateS3Object{}
+		if err := cert.LoadObject(s3Svc, s3Bucket, objectKey); err != nil {
+			return nil, err
+		}
+		return cert, nil
+	case StatusDenied:
+		if status.Error != nil {
+			return nil, status.Error
+		}
+		return nil, NewProtocolError(ErrCodeAccessDenied, "request %s was denied", requestId)
+	case StatusFailed:
+		if status.Error != nil {
+			return nil, status.Error
+		}
+		return nil, NewProtocolError(ErrCodeInternal, "request %s failed", requestId)
+	default:
+		return nil, nil
+	}
+}
+
+// pollCertificate returns the Certificate once it exists and was issued after issuedAfter
+func pollCertificate(s3Svc s3iface.S3API, s3Bucket string, objectKey string, issuedAfter time.Time) (*SignedCertificateS3Object, error) {
+	cert := &SignedCertificateS3Object{}
+	if err := cert.LoadObject(s3Svc, s3Bucket, objectKey); isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if cert.IssuedOn.Before(issuedAfter) {
+		return nil, nil
+	}
+	return cert, nil
+}
@@ -0,0 +1,144 @@
+package protocol_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestRequestStatusS3Object_ObjectKey(t *testing.T) {
+	c := &protocol.RequestStatusS3Object{RequestId: "0123456789abcdef0123456789abcdef"}
+	want := "schism-test/Requests/0123456789abcdef0123456789abcdef.json"
+	if got := c.ObjectKey(prefix); got != want {
+		t.Errorf("ObjectKey() = %v, want %v", got, want)
+	}
+}
+
+func TestNewRequestId(t *testing.T) {
+	first, err := protocol.NewRequestId()
+	if err != nil {
+		t.Fatalf("NewRequestId() error = %v", err)
+	}
+	second, _ := protocol.NewRequestId()
+	if len(first) != 32 || first == second {
+		t.Errorf("NewRequestId() = %v, %v, want two different 32 character ids", first, second)
+	}
+}
+
+func TestWaitForCertificate(t *testing.T) {
+	cert := &protocol.SignedCertificateS3Object{
+		CertificateType:      protocol.HostCertificate,
+		IssuedOn:             time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC),
+		Identity:             "test.example.com",
+		Principals:           []string{"test.example.com"},
+		ValidityInterval:     time.Hour,
+		RawSignedCertificate: []byte("cert"),
+	}
+	lookupKey := cert.LookupKey().String()
+	opts := &protocol.WaitOptions{InitialInterval: time.Millisecond, MaxInterval: 5 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		resp     *protocol.RequestSSHCertLambdaResponse
+		statuses []protocol.RequestStatus
+		saveCert bool
+		opts     *protocol.WaitOptions
+		wantCode protocol.ErrorCode
+	}{
+		{
+			name:     "status issued",
+			resp:     &protocol.RequestSSHCertLambdaResponse{RequestId: "req-1", LookupKey: lookupKey},
+			statuses: []protocol.RequestStatus{protocol.StatusPending, protocol.StatusApproved, protocol.StatusIssued},
+			saveCert: true,
+			opts:     opts,
+		},
+		{
+			name:     "status denied",
+			resp:     &protocol.RequestSSHCertLambdaResponse{RequestId: "req-2", LookupKey: lookupKey},
+			statuses: []protocol.RequestStatus{protocol.StatusPending, protocol.StatusDenied},
+			opts:     opts,
+			wantCode: protocol.ErrCodeAccessDenied,
+		},
+		{
+			name:     "status failed",
+			resp:     &protocol.RequestSSHCertLambdaResponse{RequestId: "req-3", LookupKey: lookupKey},
+			statuses: []protocol.RequestStatus{protocol.StatusFailed},
+			opts:     opts,
+			wantCode: protocol.ErrCodeInternal,
+		},
+		{
+			name:     "no request id",
+			resp:     &protocol.RequestSSHCertLambdaResponse{LookupKey: lookupKey},
+			saveCert: true,
+			opts:     opts,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s3Svc := fakeaws.NewS3(testValidBucket)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			done := make(chan struct{})
+			defer func() { <-done }()
+			go func() {
+				defer close(done)
+				for _, st := range tt.statuses {
+					time.Sleep(2 * time.Millisecond)
+					if st == protocol.StatusIssued && tt.saveCert {
+						_ = cert.SaveObject(s3Svc, testValidBucket, prefix)
+					}
+					status := &protocol.RequestStatusS3Object{RequestId: tt.resp.RequestId, Status: st, LookupKey: lookupKey}
+					if st == protocol.StatusIssued {
+						status.CertificateObjectKey = cert.HistoryObjectKey(prefix)
+					}
+					_ = status.SaveObject(s3Svc, testValidBucket, prefix)
+				}
+				if len(tt.statuses) == 0 && tt.saveCert {
+					time.Sleep(2 * time.Millisecond)
+					_ = cert.SaveObject(s3Svc, testValidBucket, prefix)
+				}
+			}()
+
+			got, err := protocol.WaitForCertificate(ctx, s3Svc, testValidBucket, prefix, tt.resp, tt.opts)
+			if tt.wantCode != "" {
+				var perr *protocol.ProtocolError
+				if !errors.As(err, &perr) || perr.Code != tt.wantCode {
+					t.Fatalf("WaitForCertificate() error = %v, want code %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WaitForCertificate() error = %v", err)
+			}
+			if got.LookupKey().String() != lookupKey || string(got.RawSignedCertificate) != "cert" {
+				t.Errorf("WaitForCertificate() = %+v, want %+v", got, cert)
+			}
+		})
+	}
+}
+
+func TestWaitForCertificate_IssuedAfter(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	old := &protocol.SignedCertificateS3Object{
+		CertificateType: protocol.HostCertificate,
+		IssuedOn:        time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC),
+		Identity:        "test.example.com",
+		Principals:      []string{"test.example.com"},
+	}
+	if err := old.SaveObject(s3Svc, testValidBucket, prefix); err != nil {
+		t.Fatalf("SaveObject() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	resp := &protocol.RequestSSHCertLambdaResponse{LookupKey: old.LookupKey().String()}
+	opts := &protocol.WaitOptions{InitialInterval: time.Millisecond, IssuedAfter: old.IssuedOn.Add(time.Minute)}
+	if _, err := protocol.WaitForCertificate(ctx, s3Svc, testValidBucket, prefix, resp, opts); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForCertificate() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,347 @@
+package protocol_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"encoding/json"
+	"path/filepath"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+//go:generate go test -run TestJSONSchemas -update .
+
+var updateSchemas = flag.Bool("update", false, "regenerate the JSON Schemas in schemas/")
+
+const (
+	schemaDir     = "schemas"
+	schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+	schemaBaseURL = "https://code.agarg.me/schism/commonLib/-/raw/main/protocol/schemas/"
+)
+
+// schemaTypes are the types non-Go tooling reads, each gets a {file}.schema.json
+//
+// The values are the smallest valid instances, e.g. a pending asynchronous response has no CertType
+var schemaTypes = map[string]interface{}{
+	"request_ssh_cert_lambda_payload":  protocol.RequestSSHCertLambdaPayload{CertificateType: protocol.UserCertificate},
+	"request_ssh_cert_lambda_response": protocol.RequestSSHCertLambdaResponse{RequestId: "0123"},
+	"signed_certificate_s3_object":     protocol.SignedCertificateS3Object{CertificateType: protocol.UserCertificate},
+	"ca_public_key_s3_object":          protocol.CAPublicKeyS3Object{CertificateType: protocol.UserCertificate},
+}
+
+// TestJSONSchemas fails when the shipped schemas drift from the Go types, run `go generate` to fix
+func TestJSONSchemas(t *testing.T) {
+	docs, err := parseDocComments(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, v := range schemaTypes {
+		t.Run(file, func(t *testing.T) {
+			path := filepath.Join(schemaDir, file+".schema.json")
+			gen := &schemaGenerator{docs: docs, defs: map[string]*jsonSchema{}}
+			schema := gen.root(reflect.TypeOf(v), schemaBaseURL+file+".schema.json")
+			got, err := marshalSchema(schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *updateSchemas {
+				if err := ioutil.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v, run `go generate ./protocol`", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s is out of date with %T, run `go generate ./protocol`", path, v)
+			}
+		})
+	}
+}
+
+// TestJSONSchemas_Required checks the schemas agree with what the Go types actually emit
+func TestJSONSchemas_Required(t *testing.T) {
+	for file, v := range schemaTypes {
+		t.Run(file, func(t *testing.T) {
+			raw, err := ioutil.ReadFile(filepath.Join(schemaDir, file+".schema.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var schema struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+				Required   []string                   `json:"required"`
+			}
+			if err = json.Unmarshal(raw, &schema); err != nil {
+				t.Fatal(err)
+			}
+			body, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var emitted map[string]json.RawMessage
+			if err = json.Unmarshal(body, &emitted); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range schema.Required {
+				if _, ok := emitted[name]; !ok {
+					t.Errorf("required property %s is not emitted by a minimal %T", name, v)
+				}
+			}
+			for name, value := range emitted {
+				property, ok := schema.Properties[name]
+				if !ok {
+					t.Errorf("property %s emitted by %T is missing from the schema", name, v)
+					continue
+				}
+				var enum struct {
+					Enum []json.RawMessage `json:"enum"`
+				}
+				if err = json.Unmarshal(property, &enum); err != nil {
+					t.Fatal(err)
+				}
+				if len(enum.Enum) > 0 && !containsJSON(enum.Enum, value) {
+					t.Errorf("property %s emitted by %T as %s is not in the schema enum", name, v, value)
+				}
+			}
+		})
+	}
+}
+
+func containsJSON(values []json.RawMessage, value json.RawMessage) bool {
+	for _, v := range values {
+		if bytes.Equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonSchema is the subset of JSON Schema the protocol types need, fields are in output order
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Properties           schemaProperties       `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+// schemaProperties keeps properties in struct field order
+type schemaProperties []schemaProperty
+
+type schemaProperty struct {
+	Name   string
+	Schema *jsonSchema
+}
+
+func (p schemaProperties) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, prop := range p {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(prop.Name)
+		value, err := json.Marshal(prop.Schema)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func marshalSchema(schema *jsonSchema) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(schema); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// schemaGenerator turns Go types into schemas, following encoding/json's rules for struct tags
+type schemaGenerator struct {
+	// doc comments by "Type" and "Type.Field"
+	docs map[string]string
+	defs map[string]*jsonSchema
+}
+
+var (
+	certTypeType           = reflect.TypeOf(protocol.CertType(""))
+	lookupKeyVersionType   = reflect.TypeOf(protocol.LookupKeyVersion(""))
+	schemaVersionType      = reflect.TypeOf(protocol.SchemaVersion(""))
+	certificateOptionsType = reflect.TypeOf(protocol.CertificateOptions{})
+	timeType               = reflect.TypeOf(time.Time{})
+	durationType           = reflect.TypeOf(time.Duration(0))
+)
+
+func (g *schemaGenerator) root(t reflect.Type, id string) *jsonSchema {
+	schema := g.object(t)
+	schema.Schema, schema.ID, schema.Title = schemaDialect, id, t.Name()
+	if len(g.defs) > 0 {
+		schema.Defs = g.defs
+	}
+	return schema
+}
+
+func (g *schemaGenerator) object(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{Type: "object", Description: g.docs[t.Name()]}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		prop := g.schema(field.Type)
+		if doc := g.docs[t.Name()+"."+field.Name]; doc != "" && prop.Description != "" {
+			prop.Description = doc + "\n\n" + prop.Description
+		} else if doc != "" {
+			prop.Description = doc
+		}
+		schema.Properties = append(schema.Properties, schemaProperty{Name: name, Schema: prop})
+		if !strings.Contains(opts, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *jsonSchema {
+	switch t {
+	case certTypeType:
+		// An unset CertType can't be marshaled and "cakp" is never signed or stored
+		return &jsonSchema{Type: "string", Enum: []string{string(protocol.HostCertificate), string(protocol.UserCertificate)}}
+	case lookupKeyVersionType:
+		return &jsonSchema{Type: "string", Enum: []string{string(protocol.LookupKeyV1), string(protocol.LookupKeyV2)}}
+	case timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case schemaVersionType:
+		return &jsonSchema{Type: "string", Enum: []string{string(protocol.SchemaV1), string(protocol.SchemaV2)}}
+	case durationType:
+		return &jsonSchema{AnyOf: []*jsonSchema{
+			{Type: "integer", Description: "Nanoseconds, written when schema_version is empty"},
+			{Type: "string", Description: `Written when schema_version is "v2", e.g. "12h", "5d" or "1d12h30m". ISO-8601 durations like "P1DT12H" are also accepted`},
+		}}
+	case certificateOptionsType:
+		g.define(t)
+		return &jsonSchema{AnyOf: []*jsonSchema{
+			{Type: "array", Items: &jsonSchema{Type: "string"}, Description: "`ssh-keygen -O` style options, always used when encoding"},
+			{Ref: "#/$defs/" + t.Name()},
+		}}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0
+		return &jsonSchema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &jsonSchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		g.define(t)
+		return &jsonSchema{Ref: "#/$defs/" + t.Name()}
+	}
+	panic(fmt.Sprintf("no JSON Schema mapping for %s", t))
+}
+
+// define adds the object form of struct t to $defs
+func (g *schemaGenerator) define(t reflect.Type) {
+	if _, ok := g.defs[t.Name()]; !ok {
+		g.defs[t.Name()] = nil
+		g.defs[t.Name()] = g.object(t)
+	}
+}
+
+// parseDocComments collects the doc comments of every struct and struct field in dir,
+// dropping TODO lines
+func parseDocComments(dir string) (map[string]string, error) {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), dir, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	docs := map[string]string{}
+	clean := func(group *ast.CommentGroup) string {
+		var lines []string
+		for _, line := range strings.Split(group.Text(), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "TODO") {
+				lines = append(lines, line)
+			}
+		}
+		return strings.TrimSpace(strings.Join(lines, "\n"))
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					typeSpec := spec.(*ast.TypeSpec)
+					st, ok := typeSpec.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					if typeSpec.Doc != nil {
+						docs[typeSpec.Name.Name] = clean(typeSpec.Doc)
+					} else if gen.Doc != nil {
+						docs[typeSpec.Name.Name] = clean(gen.Doc)
+					}
+					for _, field := range st.Fields.List {
+						if field.Doc == nil {
+							continue
+						}
+						for _, name := range field.Names {
+							docs[typeSpec.Name.Name+"."+name.Name] = clean(field.Doc)
+						}
+					}
+				}
+			}
+		}
+	}
+	return docs, nil
+}
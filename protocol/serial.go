@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3SerialIndexPrefix The subprefix for the certificate serial index
+//   Full Object path will follow this template
+//    {profile.S3Prefix}{S3SerialIndexPrefix}{serial as 16 hex digits}.json
+const S3SerialIndexPrefix = "Serials/"
+
+// DefaultSerialAttempts is how many random serials SerialAllocator tries before giving up
+const DefaultSerialAttempts = 5
+
+// SerialIndexS3Object maps a certificate serial to the issuance it was used for,
+// so KRLs and logs referencing a serial can be traced back to the Certificate
+type SerialIndexS3Object struct {
+	// Serial number of the Certificate
+	Serial uint64 `json:"serial"`
+	// Type of SSH-cert the serial was used for
+	CertificateType CertType `json:"certificate_type"`
+	// LookupKey.String() of the Certificate
+	LookupKey string `json:"lookup_key"`
+	// S3 ObjectKey of the archived issuance, see SignedCertificateS3Object.HistoryObjectKey
+	HistoryObjectKey string `json:"history_object_key"`
+}
+
+// ObjectKey given a prefix, return the key of the index entry for the serial
+//
+//  Format:
+//   {prefix}{S3SerialIndexPrefix}{%016x}.json
+func (c *SerialIndexS3Object) ObjectKey(prefix string) string {
+	return serialObjectKey(prefix, c.Serial)
+}
+
+// LoadObject loads an object from the given s3://{s3Bucket}/{s3ObjectKey} and un-marshals it into a SerialIndexS3Object
+func (c *SerialIndexS3Object) LoadObject(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string) error {
+	body, err := rawLoadS3Object(s3Svc, s3Bucket, s3ObjectKey, nil)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, c); err != nil {
+		return fmt.Errorf("unable to unmarshal object (%s): %w", s3ObjectKey, err)
+	}
+	return nil
+}
+
+// SerialAllocator hands out random non-zero 64-bit certificate serials
+// that aren't already present in the serial index.
+//
+// Serials are claimed when the Certificate is saved with SignedCertificateS3Object.SaveObject,
+// with 64 random bits two concurrent Lambda invocations picking the same serial
+// in between the check and the save is vanishingly unlikely.
+type SerialAllocator struct {
+	S3Svc    s3iface.S3API
+	S3Bucket string
+	S3Prefix string
+	// Source of randomness, defaults to crypto/rand.Reader
+	Rand io.Reader
+	// How many serials to try before giving up, defaults to DefaultSerialAttempts
+	MaxAttempts int
+}
+
+// Allocate returns a serial that isn't in use yet
+//
+// Returns an error if S3 calls fail or every attempt collided with an existing serial
+func (a *SerialAllocator) Allocate() (uint64, error) {
+	random := a.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+	attempts := a.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultSerialAttempts
+	}
+	var buf [8]byte
+	for i := 0; i < attempts; i++ {
+		if _, err := io.ReadFull(random, buf[:]); err != nil {
+			return 0, fmt.Errorf("unable to generate serial: %w", err)
+		}
+		serial := binary.BigEndian.Uint64(buf[:])
+		if serial == 0 {
+			continue
+		}
+		_, err := a.S3Svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(a.S3Bucket),
+			Key:    aws.String(serialObjectKey(a.S3Prefix, serial)),
+		})
+		if isNotFound(err) {
+			return serial, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("unable to allocate an unused serial after %d attempts", attempts)
+}
+
+// LoadBySerial follows the serial index to the issuance the serial was used for
+func LoadBySerial(s3Svc s3iface.S3API, s3Bucket string, prefix string, serial uint64) (*SignedCertificateS3Object, error) {
+	index := &SerialIndexS3Object{}
+	if err := index.LoadObject(s3Svc, s3Bucket, serialObjectKey(prefix, serial)); err != nil {
+		return nil, err
+	}
+	cert := &SignedCertificateS3Object{}
+	if err := cert.LoadObject(s3Svc, s3Bucket, index.HistoryObjectKey); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func serialObjectKey(prefix string, serial uint64) string {
+	return fmt.Sprintf("%s%s%016x.json", prefix, S3SerialIndexPrefix, serial)
+}
+
+// isNotFound reports whether err is (or wraps) S3 saying the object doesn't exist, or ErrLookupKeyNotFound,
+// HeadObject responses have no body so the code is "NotFound" rather than s3.ErrCodeNoSuchKey
+func isNotFound(err error) bool {
+	if errors.Is(err, ErrLookupKeyNotFound) {
+		return true
+	}
+	var aErr awserr.Error
+	return errors.As(err, &aErr) && (aErr.Code() == s3.ErrCodeNoSuchKey || aErr.Code() == "NotFound")
+}
@@ -0,0 +1,118 @@
+package protocol_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestSerialAllocator_Allocate(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	taken := &protocol.SerialIndexS3Object{Serial: 1}
+	if err := s3Svc.Put(testValidBucket, taken.ObjectKey(prefix), []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		random  []byte
+		bucket  string
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name:   "unused serial is returned",
+			random: []byte{0, 0, 0, 0, 0, 0, 0, 2},
+			bucket: testValidBucket,
+			want:   2,
+		},
+		{
+			name:   "zero and taken serials are skipped",
+			random: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 3},
+			bucket: testValidBucket,
+			want:   3,
+		},
+		{
+			name:    "gives up after MaxAttempts",
+			random:  []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1},
+			bucket:  testValidBucket,
+			wantErr: true,
+		},
+		{
+			name:    "errors from S3 are returned",
+			random:  []byte{0, 0, 0, 0, 0, 0, 0, 2},
+			bucket:  "this-bucket-is-a-lie",
+			wantErr: true,
+		},
+		{
+			name:    "running out of randomness is an error",
+			random:  []byte{1, 2, 3},
+			bucket:  testValidBucket,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &protocol.SerialAllocator{
+				S3Svc:       s3Svc,
+				S3Bucket:    tt.bucket,
+				S3Prefix:    prefix,
+				Rand:        bytes.NewReader(tt.random),
+				MaxAttempts: 3,
+			}
+			got, err := a.Allocate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Allocate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Allocate() got = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadBySerial(t *testing.T) {
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	serial, err := (&protocol.SerialAllocator{S3Svc: s3Svc, S3Bucket: testValidBucket, S3Prefix: prefix}).Allocate()
+	if err != nil {
+		t.Fatalf("Allocate() error = %v", err)
+	}
+	first := &protocol.SignedCertificateS3Object{
+		CertificateType: protocol.HostCertificate,
+		Serial:          serial,
+		IssuedOn:        time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC),
+		Identity:        "test.example.com",
+		Principals:      []string{"test.example.com"},
+	}
+	second := *first
+	second.Serial = serial + 1
+	second.IssuedOn = first.IssuedOn.Add(time.Hour)
+	for _, c := range []*protocol.SignedCertificateS3Object{first, &second} {
+		if err = c.SaveObject(s3Svc, testValidBucket, prefix); err != nil {
+			t.Fatalf("SaveObject() error = %v", err)
+		}
+	}
+
+	got, err := protocol.LoadBySerial(s3Svc, testValidBucket, prefix, serial)
+	if err != nil {
+		t.Fatalf("LoadBySerial() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, first) {
+		t.Errorf("LoadBySerial() got = %+v, want %+v", got, first)
+	}
+
+	index := &protocol.SerialIndexS3Object{}
+	if err = index.LoadObject(s3Svc, testValidBucket, (&protocol.SerialIndexS3Object{Serial: serial}).ObjectKey(prefix)); err != nil {
+		t.Fatalf("LoadObject() error = %v", err)
+	}
+	if index.LookupKey != "host:"+hostTestExampleComKey {
+		t.Errorf("SerialIndexS3Object.LookupKey = %v", index.LookupKey)
+	}
+
+	if _, err = protocol.LoadBySerial(s3Svc, testValidBucket, prefix, 42); err == nil {
+		t.Error("LoadBySerial() expected an error for an unknown serial")
+	}
+}
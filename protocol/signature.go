@@ -0,0 +1,175 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
+)
+
+// S3 user metadata keys holding the detached signature of an object
+const (
+	// base64 of the ssh wire format ssh.Signature
+	SignatureMetadataKey = "Schism-Signature"
+	// ssh.FingerprintSHA256 of the key that made the signature
+	SignerMetadataKey = "Schism-Signer"
+)
+
+// objectSignatureMagic is prepended to every signed object
+// so the signature can't be replayed as a signature over something else
+const objectSignatureMagic = "schism-object-signature-v1"
+
+// Errors returned (wrapped) when verifying signed S3 objects
+var (
+	ErrSignatureMissing = errors.New("s3 object is not signed")
+	ErrSignatureInvalid = errors.New("s3 object signature is invalid")
+)
+
+// TrustAnchor is the set of pinned public keys allowed to sign S3 objects
+type TrustAnchor map[string]ssh.PublicKey
+
+// NewTrustAnchor pins the given keys
+func NewTrustAnchor(keys ...ssh.PublicKey) TrustAnchor {
+	anchor := TrustAnchor{}
+	for _, key := range keys {
+		anchor[ssh.FingerprintSHA256(key)] = key
+	}
+	return anchor
+}
+
+// ParseTrustAnchor pins every key in an authorized_keys formatted file
+//
+// Returns an error if a line cannot be parsed or there are no keys
+func ParseTrustAnchor(authorizedKeys []byte) (TrustAnchor, error) {
+	anchor := TrustAnchor{}
+	for rest := authorizedKeys; len(bytes.TrimSpace(rest)) > 0; {
+		key, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse trust anchor: %w", err)
+		}
+		anchor[ssh.FingerprintSHA256(key)] = key
+		rest = next
+	}
+	if len(anchor) == 0 {
+		return nil, errors.New("trust anchor has no keys")
+	}
+	return anchor, nil
+}
+
+// CanonicalJSON re-encodes a JSON document with sorted object keys and no insignificant whitespace,
+// so equal documents always produce the same bytes to sign
+func CanonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// signedPayload returns the bytes signed for an object
+//
+//  Format:
+//   schism-object-signature-v1\n{s3ObjectKey}\n{CanonicalJSON(body)}
+//
+// The key is included so a signed object can't be copied to another key, e.g. a user CA over the host CA
+func signedPayload(s3ObjectKey string, body []byte) ([]byte, error) {
+	canonical, err := CanonicalJSON(body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to canonicalize object (%s): %w", s3ObjectKey, err)
+	}
+	return append([]byte(fmt.Sprintf("%s\n%s\n", objectSignatureMagic, s3ObjectKey)), canonical...), nil
+}
+
+// signObject signs body and adds the signature to metadata
+func signObject(metadata map[string]*string, s3ObjectKey string, body []byte, signer ssh.Signer) error {
+	payload, err := signedPayload(s3ObjectKey, body)
+	if err != nil {
+		return err
+	}
+	sig, err := signer.Sign(nil, payload)
+	if err != nil {
+		return fmt.Errorf("unable to sign object (%s): %w", s3ObjectKey, err)
+	}
+	encoded, fingerprint := base64.StdEncoding.EncodeToString(ssh.Marshal(sig)), ssh.FingerprintSHA256(signer.PublicKey())
+	metadata[SignatureMetadataKey] = &encoded
+	metadata[SignerMetadataKey] = &fingerprint
+	return nil
+}
+
+// verifyObject checks the signature in metadata was made over body by a key pinned in anchor
+func verifyObject(anchor TrustAnchor, metadata map[string]*string, s3ObjectKey string, body []byte) error {
+	encoded, hasSig := metadataValue(metadata, SignatureMetadataKey)
+	fingerprint, hasSigner := metadataValue(metadata, SignerMetadataKey)
+	if !hasSig || !hasSigner {
+		return fmt.Errorf("%w: %s", ErrSignatureMissing, s3ObjectKey)
+	}
+	key, ok := anchor[fingerprint]
+	if !ok {
+		return fmt.Errorf("%w: %s is signed by %s which is not a trusted key", ErrSignatureInvalid, s3ObjectKey, fingerprint)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrSignatureInvalid, s3ObjectKey, err)
+	}
+	sig := &ssh.Signature{}
+	if err = ssh.Unmarshal(raw, sig); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrSignatureInvalid, s3ObjectKey, err)
+	}
+	payload, err := signedPayload(s3ObjectKey, body)
+	if err != nil {
+		return err
+	}
+	if err = key.Verify(payload, sig); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrSignatureInvalid, s3ObjectKey, err)
+	}
+	return nil
+}
+
+// SaveSignedObject saves obj under obj.ObjectKey(prefix) with a detached signature by signer
+func SaveSignedObject(s3Svc s3iface.S3API, s3Bucket string, prefix string, obj S3Object, signer ssh.Signer) error {
+	return rawSaveSignedS3Object(s3Svc, s3Bucket, obj.ObjectKey(prefix), obj, signer)
+}
+
+// LoadVerifiedObject is LoadObject for any S3Object,
+// but obj is only populated once the object's signature verifies against anchor
+//
+// Returns an error wrapping ErrSignatureMissing or ErrSignatureInvalid if verification fails
+func LoadVerifiedObject(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, obj S3Object, anchor TrustAnchor) error {
+	body, object, err := rawGetS3Object(s3Svc, s3Bucket, s3ObjectKey, nil)
+	if err != nil {
+		return err
+	}
+	if err = verifyObject(anchor, object.Metadata, s3ObjectKey, body); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, obj); err != nil {
+		return fmt.Errorf("unable to unmarshal object (%s): %w", s3ObjectKey, err)
+	}
+	return nil
+}
+
+// LoadVerified is Load, but the object's signature must verify against anchor, see LoadVerifiedObject
+func LoadVerified[T any, PT s3ObjectPtr[T]](s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, anchor TrustAnchor) (*T, error) {
+	obj := new(T)
+	if err := LoadVerifiedObject(s3Svc, s3Bucket, s3ObjectKey, PT(obj), anchor); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// LoadVerifiedObject is LoadObject, but only returns data signed by a key pinned in anchor
+func (c *SignedCertificateS3Object) LoadVerifiedObject(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, anchor TrustAnchor) error {
+	return LoadVerifiedObject(s3Svc, s3Bucket, s3ObjectKey, c, anchor)
+}
+
+// LoadVerifiedObject is LoadObject, but only returns data signed by a key pinned in anchor
+func (c *CAPublicKeyS3Object) LoadVerifiedObject(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, anchor TrustAnchor) error {
+	return LoadVerifiedObject(s3Svc, s3Bucket, s3ObjectKey, c, anchor)
+}
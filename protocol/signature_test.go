@@ -0,0 +1,138 @@
+package protocol_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/fakeaws"
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	got, err := protocol.CanonicalJSON([]byte(`{ "b": [1, 2.50, {"d": 1, "c": 2}], "a": "x" }`))
+	if err != nil {
+		t.Fatalf("CanonicalJSON() error = %v", err)
+	}
+	if want := `{"a":"x","b":[1,2.50,{"c":2,"d":1}]}`; string(got) != want {
+		t.Errorf("CanonicalJSON() got = %s, want %s", got, want)
+	}
+}
+
+func TestParseTrustAnchor(t *testing.T) {
+	first, second := newTestSigner(t), newTestSigner(t)
+	keys := append(ssh.MarshalAuthorizedKey(first.PublicKey()), ssh.MarshalAuthorizedKey(second.PublicKey())...)
+	anchor, err := protocol.ParseTrustAnchor(keys)
+	if err != nil {
+		t.Fatalf("ParseTrustAnchor() error = %v", err)
+	}
+	if len(anchor) != 2 || anchor[ssh.FingerprintSHA256(second.PublicKey())] == nil {
+		t.Errorf("ParseTrustAnchor() got = %v, want both keys", anchor)
+	}
+	if _, err = protocol.ParseTrustAnchor([]byte("\n")); err == nil {
+		t.Errorf("ParseTrustAnchor() error = %v, wantErr %v", err, true)
+	}
+}
+
+func TestLoadVerifiedObject(t *testing.T) {
+	root, rogue := newTestSigner(t), newTestSigner(t)
+	anchor := protocol.NewTrustAnchor(root.PublicKey())
+	s3Svc := fakeaws.NewS3(testValidBucket)
+
+	hostCA := &protocol.CAPublicKeyS3Object{
+		CertificateType: protocol.HostCertificate,
+		AuthorizedKey:   ssh.MarshalAuthorizedKey(newTestSigner(t).PublicKey()),
+	}
+	userCA := &protocol.CAPublicKeyS3Object{
+		CertificateType: protocol.UserCertificate,
+		AuthorizedKey:   ssh.MarshalAuthorizedKey(newTestSigner(t).PublicKey()),
+	}
+	if err := protocol.SaveSignedObject(s3Svc, testValidBucket, prefix, hostCA, root); err != nil {
+		t.Fatalf("SaveSignedObject() error = %v", err)
+	}
+	if err := protocol.SaveSignedObject(s3Svc, testValidBucket, "rogue/", userCA, rogue); err != nil {
+		t.Fatalf("SaveSignedObject() error = %v", err)
+	}
+	if err := protocol.SaveSignedObject(s3Svc, testValidBucket, "copied/", userCA, root); err != nil {
+		t.Fatalf("SaveSignedObject() error = %v", err)
+	}
+	cert, _ := newTestCertificate(t, protocol.UserCertificate, "alice", []string{"alice"}, time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC), time.Hour)
+	if err := cert.SaveSignedObject(s3Svc, testValidBucket, prefix, root); err != nil {
+		t.Fatalf("SaveSignedObject() error = %v", err)
+	}
+	if err := cert.SaveObject(s3Svc, testValidBucket, "unsigned/"); err != nil {
+		t.Fatalf("SaveObject() error = %v", err)
+	}
+
+	hostKey := hostCA.ObjectKey(prefix)
+	// Keep the host CA's signature but swap in a different body
+	swapped := s3Svc.Object(testValidBucket, hostKey)
+	metadata := map[string]string{}
+	for name, value := range swapped.Metadata {
+		if name != protocol.ChecksumMetadataKey {
+			metadata[name] = value
+		}
+	}
+	swappedSvc := fakeaws.NewS3(testValidBucket)
+	_, _ = swappedSvc.PutObject(putInput(hostKey, s3Svc.Object(testValidBucket, userCA.ObjectKey("copied/")).Body, metadata))
+
+	tests := []struct {
+		name    string
+		s3Svc   *fakeaws.S3
+		key     string
+		obj     protocol.S3Object
+		wantErr error
+	}{
+		{name: "signed host CA", key: hostKey, obj: &protocol.CAPublicKeyS3Object{}},
+		{name: "signed certificate", key: cert.ObjectKey(prefix), obj: &protocol.SignedCertificateS3Object{}},
+		{name: "signed certificate history", key: cert.HistoryObjectKey(prefix), obj: &protocol.SignedCertificateS3Object{}},
+		{name: "unsigned certificate", key: cert.ObjectKey("unsigned/"), obj: &protocol.SignedCertificateS3Object{}, wantErr: protocol.ErrSignatureMissing},
+		{name: "signed by an untrusted key", key: userCA.ObjectKey("rogue/"), obj: &protocol.CAPublicKeyS3Object{}, wantErr: protocol.ErrSignatureInvalid},
+		{name: "body swapped under a signature", s3Svc: swappedSvc, key: hostKey, obj: &protocol.CAPublicKeyS3Object{}, wantErr: protocol.ErrSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := s3Svc
+			if tt.s3Svc != nil {
+				svc = tt.s3Svc
+			}
+			err := protocol.LoadVerifiedObject(svc, testValidBucket, tt.key, tt.obj, anchor)
+			if (err != nil) != (tt.wantErr != nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("LoadVerifiedObject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("signature is bound to the object key", func(t *testing.T) {
+		copied := s3Svc.Object(testValidBucket, userCA.ObjectKey("copied/"))
+		_, _ = s3Svc.PutObject(putInput(hostKey, copied.Body, copied.Metadata))
+		got := &protocol.CAPublicKeyS3Object{}
+		if err := got.LoadVerifiedObject(s3Svc, testValidBucket, hostKey, anchor); !errors.Is(err, protocol.ErrSignatureInvalid) {
+			t.Errorf("LoadVerifiedObject() error = %v, wantErr %v", err, protocol.ErrSignatureInvalid)
+		}
+	})
+
+	t.Run("generic loader", func(t *testing.T) {
+		got, err := protocol.LoadVerified[protocol.SignedCertificateS3Object](s3Svc, testValidBucket, cert.ObjectKey(prefix), anchor)
+		if err != nil {
+			t.Fatalf("LoadVerified() error = %v", err)
+		}
+		if got.LookupKey().String() != cert.LookupKey().String() {
+			t.Errorf("LoadVerified() got = %v, want %v", got.LookupKey(), cert.LookupKey())
+		}
+	})
+}
+
+func putInput(key string, body []byte, metadata map[string]string) *s3.PutObjectInput {
+	return &s3.PutObjectInput{
+		Bucket:   aws.String(testValidBucket),
+		Key:      aws.String(key),
+		Body:     bytes.NewReader(body),
+		Metadata: aws.StringMap(metadata),
+	}
+}
@@ -1,16 +1,18 @@
 package protocol
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"encoding/json"
-	"io/ioutil"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
 )
 
 // CertType: Schism supports two types of certificates: "user" and "host"
@@ -29,12 +31,96 @@ const (
 	CaKeyPair CertType = "cakp"
 )
 
+// ErrInvalidCertType is returned when a CertType isn't one of the valid options
+var ErrInvalidCertType = errors.New("invalid certificate type")
+
+// ParseCertType returns the full CertType for s, which may be a single character type (see Expand)
+//
+// Unlike Expand, unknown types are an error
+func ParseCertType(s string) (CertType, error) {
+	if ct := CertType(s).Expand(); ct != "" {
+		return ct, nil
+	}
+	return "", fmt.Errorf("%w: '%s'", ErrInvalidCertType, s)
+}
+
+// CertTypeFromSSH returns the CertType for an ssh.Certificate's CertType
+func CertTypeFromSSH(sshCertType uint32) (CertType, error) {
+	switch sshCertType {
+	case ssh.HostCert:
+		return HostCertificate, nil
+	case ssh.UserCert:
+		return UserCertificate, nil
+	default:
+		return "", fmt.Errorf("%w: ssh certificate type %d", ErrInvalidCertType, sshCertType)
+	}
+}
+
+// SSHCertType returns ssh.HostCert or ssh.UserCert, CaKeyPair has no ssh.Certificate equivalent
+func (ct CertType) SSHCertType() (uint32, error) {
+	switch ct.Expand() {
+	case HostCertificate:
+		return ssh.HostCert, nil
+	case UserCertificate:
+		return ssh.UserCert, nil
+	default:
+		return 0, fmt.Errorf("%w: '%s' has no ssh certificate type", ErrInvalidCertType, ct)
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler, single character types are written expanded
+//
+// The empty CertType is left unset, anything else must be valid.
+// Types that must be "host" or "user" are checked by the Validate of the struct holding them
+func (ct CertType) MarshalText() ([]byte, error) {
+	if ct == "" {
+		return []byte{}, nil
+	}
+	expanded, err := ParseCertType(string(ct))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(expanded), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, rejecting unknown types
+// so invalid payloads and objects fail to decode
+func (ct *CertType) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*ct = ""
+		return nil
+	}
+	parsed, err := ParseCertType(string(text))
+	if err != nil {
+		return err
+	}
+	*ct = parsed
+	return nil
+}
+
+// ErrNoOppositeCA is returned by OppositeCA for types that aren't "host" or "user"
+var ErrNoOppositeCA = fmt.Errorf("%w: only host and user types have an opposite CA", ErrInvalidCertType)
+
 // OppositeCA returns "host" for "user" and "user" for "host"
-func (ct CertType) OppositeCA() CertType {
-	return (map[CertType]CertType{
-		HostCertificate: UserCertificate,
-		UserCertificate: HostCertificate,
-	})[ct]
+//
+// Returns an error wrapping ErrNoOppositeCA for CaKeyPair or any other type
+func (ct CertType) OppositeCA() (CertType, error) {
+	switch ct.Expand() {
+	case HostCertificate:
+		return UserCertificate, nil
+	case UserCertificate:
+		return HostCertificate, nil
+	default:
+		return "", fmt.Errorf("%w: '%s'", ErrNoOppositeCA, ct)
+	}
+}
+
+// requireSignable checks ct is a type Schism signs certificates for, "host" or "user"
+func (ct CertType) requireSignable() error {
+	if ct != HostCertificate && ct != UserCertificate {
+		return fmt.Errorf("%w: certificate_type must be %s or %s, got '%s'", ErrInvalidCertType, HostCertificate, UserCertificate, ct)
+	}
+	return nil
 }
 
 // Expand returns the full CertType given a single character type
@@ -66,18 +152,58 @@ type S3Object interface {
 	LoadObject(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string) error
 }
 
-// rawLoadS3Object takes a bucket and key and returns the raw bytes.
-// an error is returned if s3 has issues or if the object body cannot be read for some reason
-func rawLoadS3Object(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string) ([]byte, error) {
+// rawLoadS3Object takes a bucket and key and returns the raw bytes, checked against opts (DefaultLoadOptions if nil).
+// an error is returned if s3 has issues, the object body cannot be read or a check fails
+func rawLoadS3Object(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, opts *LoadOptions) ([]byte, error) {
+	body, _, err := rawGetS3Object(s3Svc, s3Bucket, s3ObjectKey, opts)
+	return body, err
+}
+
+// rawGetS3Object is rawLoadS3Object but also returns the GetObject response for its metadata,
+// the response Body is already closed
+func rawGetS3Object(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, loadOpts *LoadOptions) ([]byte, *s3.GetObjectOutput, error) {
+	opts := loadOpts.orDefault()
 	object, err := s3Svc.GetObject(&s3.GetObjectInput{
 		Bucket: aws.String(s3Bucket),
 		Key:    aws.String(s3ObjectKey),
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	body, _ := ioutil.ReadAll(object.Body)
-	return body, err
+	defer object.Body.Close()
+	body, err := readObjectBody(s3ObjectKey, object.Body, aws.Int64Value(object.ContentLength), aws.StringValue(object.ContentType), object.Metadata, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, object, nil
+}
+
+// rawSaveS3Object marshals obj to JSON and saves it to s3://{s3Bucket}/{s3ObjectKey}
+func rawSaveS3Object(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, obj S3Object) error {
+	return rawSaveSignedS3Object(s3Svc, s3Bucket, s3ObjectKey, obj, nil)
+}
+
+// rawSaveSignedS3Object is rawSaveS3Object but also stores a detached signature
+// in the object's metadata when signer isn't nil, see SaveSignedObject
+func rawSaveSignedS3Object(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string, obj S3Object, signer ssh.Signer) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("unable to marshal object (%s): %w", s3ObjectKey, err)
+	}
+	metadata := map[string]*string{ChecksumMetadataKey: aws.String(checksum(body))}
+	if signer != nil {
+		if err = signObject(metadata, s3ObjectKey, body, signer); err != nil {
+			return err
+		}
+	}
+	_, err = s3Svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s3Bucket),
+		Key:         aws.String(s3ObjectKey),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(jsonContentType),
+		Metadata:    metadata,
+	})
+	return err
 }
 
 // S3CaPubkeyPrefix The subprefix for storing the Public CA keys
@@ -95,6 +221,8 @@ const S3CertStoragePrefix = "Signed-Certs/"
 type SignedCertificateS3Object struct {
 	// Type of SSH-cert to be saved
 	CertificateType CertType `json:"certificate_type"`
+	// Serial number of the Certificate, see SerialAllocator
+	Serial uint64 `json:"serial,omitempty"`
 	// Timestamp of when we minted the cert
 	IssuedOn time.Time `json:"issued_on"`
 	// Originally requested Identity for this Certificate
@@ -103,6 +231,12 @@ type SignedCertificateS3Object struct {
 	Principals []string `json:"certificate_principals"`
 	// How long will this Certificate be valid for?
 	ValidityInterval time.Duration `json:"validity_interval"`
+	// Absolute window the Certificate was signed for, including any backdating.
+	// See Window() for objects saved before these were recorded
+	ValidAfter  time.Time `json:"valid_after"`
+	ValidBefore time.Time `json:"valid_before"`
+	// Critical options and extensions the Certificate was signed with
+	CertificateOptions *CertificateOptions `json:"certificate_options,omitempty"`
 	// The raw representation of this Certificate after Marshaling
 	// TODO: Work on KMS encryption for this section
 	RawSignedCertificate []byte `json:"signed_certificate"`
@@ -113,21 +247,58 @@ type SignedCertificateS3Object struct {
 	OppositePublicCA string `json:"opposite_public_ca"`
 	// TODO: To be implemented later.
 	SignedCertificateEncryption map[string]string `json:"signed_certificate_encryption,omitempty"`
+	// The Fingerprint of the signed PublicKey as returned by ssh.FingerprintSHA256
+	PublicKeyFingerprint string `json:"public_key_fingerprint,omitempty"`
+	// Scheme used to generate the LookupKey for this Certificate, empty for v1
+	LookupKeyVersion LookupKeyVersion `json:"lookup_key_version,omitempty"`
+	// HistoryObjectKey of the issuance this Certificate renewed, see LinkRenewal
+	RenewedFrom string `json:"renewed_from,omitempty"`
+	// Encoding used when saving, SchemaV2 writes ValidityInterval as a readable Duration
+	SchemaVersion SchemaVersion `json:"schema_version,omitempty"`
+}
+
+// Validate checks the Certificate is of a type Schism signs, "host" or "user"
+//
+// Called when the Certificate is encoded or decoded
+func (c *SignedCertificateS3Object) Validate() error {
+	return c.CertificateType.requireSignable()
+}
+
+// LookupKey returns the LookupKey for this Certificate using the scheme set in LookupKeyVersion
+func (c *SignedCertificateS3Object) LookupKey() *LookupKey {
+	if c.LookupKeyVersion == LookupKeyV2 {
+		return GenerateLookupKeyV2(c.Identity, c.Principals, c.CertificateType, c.PublicKeyFingerprint)
+	}
+	return GenerateLookupKey(c.Identity, c.Principals, c.CertificateType)
+}
+
+// Certificate parses RawSignedCertificate, which may be in authorized_keys or ssh wire format
+func (c *SignedCertificateS3Object) Certificate() (*ssh.Certificate, error) {
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(c.RawSignedCertificate)
+	if err != nil {
+		if pubKey, err = ssh.ParsePublicKey(c.RawSignedCertificate); err != nil {
+			return nil, fmt.Errorf("unable to parse signed certificate: %w", err)
+		}
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("signed certificate is a plain %s key", pubKey.Type())
+	}
+	return cert, nil
 }
 
-// ObjectKey  given a prefix, return a key for S3 by invoking GenerateLookupKey()
+// ObjectKey  given a prefix, return a key for S3 by invoking LookupKey()
 // and calling .String() on the result
 //
 //  Format:
 //   {prefix}{LookupKey.String()}.json
 func (c *SignedCertificateS3Object) ObjectKey(prefix string) string {
-	lookupKey := GenerateLookupKey(c.Identity, c.Principals, c.CertificateType)
-	return fmt.Sprintf("%s%s%s.json", prefix, S3CertStoragePrefix, lookupKey)
+	return c.LookupKey().ObjectKey(prefix)
 }
 
 // LoadObject loads an object from the given s3://{s3Bucket}/{s3ObjectKey} and un-marshals it into a SignedCertificateS3Object
 func (c *SignedCertificateS3Object) LoadObject(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string) error {
-	body, err := rawLoadS3Object(s3Svc, s3Bucket, s3ObjectKey)
+	body, err := rawLoadS3Object(s3Svc, s3Bucket, s3ObjectKey, nil)
 	if err != nil {
 		return err
 	}
@@ -179,7 +350,7 @@ func (c *CAPublicKeyS3Object) ObjectKey(prefix string) string {
 
 // LoadObject loads an object from the given s3://{s3Bucket}/{s3ObjectKey} and un-marshals it into a CAPublicKeyS3Object
 func (c *CAPublicKeyS3Object) LoadObject(s3Svc s3iface.S3API, s3Bucket string, s3ObjectKey string) error {
-	body, err := rawLoadS3Object(s3Svc, s3Bucket, s3ObjectKey)
+	body, err := rawLoadS3Object(s3Svc, s3Bucket, s3ObjectKey, nil)
 	if err != nil {
 		return err
 	}
@@ -1,12 +1,18 @@
 package protocol_test
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"encoding/json"
+
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"golang.org/x/crypto/ssh"
 
+	"code.agarg.me/schism/commonLib/fakeaws"
 	"code.agarg.me/schism/commonLib/protocol"
 )
 
@@ -116,8 +122,8 @@ func TestCAPublicKeyS3Object_LoadObject(t *testing.T) {
 				KeyFingerprint:  "SHA256:Gyc2MeVs5jZsL2lnDQj8C0FA6qOdZavwl+aY6APh7TM",
 			},
 			args: args{
-				s3Svc:       &protocol.MockS3Client{T: t},
-				s3Bucket:    protocol.TestValidBucket,
+				s3Svc:       newTestS3(t),
+				s3Bucket:    testValidBucket,
 				s3ObjectKey: protocol.S3CaPubkeyPrefix + "user.json",
 			},
 			wantErr: false,
@@ -125,8 +131,8 @@ func TestCAPublicKeyS3Object_LoadObject(t *testing.T) {
 		{
 			name: "Handles errors from s3 gracefully",
 			args: args{
-				s3Svc:       &protocol.MockS3Client{T: t},
-				s3Bucket:    protocol.TestValidBucket,
+				s3Svc:       newTestS3(t),
+				s3Bucket:    testValidBucket,
 				s3ObjectKey: "invalid-key",
 			},
 			wantErr: true,
@@ -134,8 +140,8 @@ func TestCAPublicKeyS3Object_LoadObject(t *testing.T) {
 		{
 			name: "Handles corrupt ca pubkeys gracefully",
 			args: args{
-				s3Svc:       &protocol.MockS3Client{T: t},
-				s3Bucket:    protocol.TestValidBucket,
+				s3Svc:       newTestS3(t),
+				s3Bucket:    testValidBucket,
 				s3ObjectKey: "empty-objects",
 			},
 			wantErr: true,
@@ -162,9 +168,11 @@ func TestCAPublicKeyS3Object_LoadObject(t *testing.T) {
 
 func TestSignedCertificateS3Object_ObjectKey(t *testing.T) {
 	type fields struct {
-		CertificateType protocol.CertType
-		Identity        string
-		Principals      []string
+		CertificateType      protocol.CertType
+		Identity             string
+		Principals           []string
+		PublicKeyFingerprint string
+		LookupKeyVersion     protocol.LookupKeyVersion
 	}
 	type args struct {
 		prefix string
@@ -195,13 +203,27 @@ func TestSignedCertificateS3Object_ObjectKey(t *testing.T) {
 			args: args{prefix: prefix},
 			want: "schism-test/Signed-Certs/user:69206403b2f940935765c084335bcd2d9caed2fbd86a7056ddab98ce698e4ce1.json",
 		},
+		{
+			name: "UserSignedCertS3Object with v2 LookupKey",
+			fields: fields{
+				CertificateType:      protocol.UserCertificate,
+				Identity:             "someUser@dev1.example.com",
+				Principals:           []string{"someUser", "admin"},
+				PublicKeyFingerprint: "SHA256:yCYTo2nP5zUcJuLWlHEJKj0jEElUE2wZvEMuh82UMQM",
+				LookupKeyVersion:     protocol.LookupKeyV2,
+			},
+			args: args{prefix: prefix},
+			want: "schism-test/Signed-Certs/user:v2:5b0936d4e0953418e95b79674ffc628d47a5ff6c7fc898d070a224da20dd99e7.json",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &protocol.SignedCertificateS3Object{
-				CertificateType: tt.fields.CertificateType,
-				Identity:        tt.fields.Identity,
-				Principals:      tt.fields.Principals,
+				CertificateType:      tt.fields.CertificateType,
+				Identity:             tt.fields.Identity,
+				Principals:           tt.fields.Principals,
+				PublicKeyFingerprint: tt.fields.PublicKeyFingerprint,
+				LookupKeyVersion:     tt.fields.LookupKeyVersion,
 			}
 			if got := c.ObjectKey(tt.args.prefix); got != tt.want {
 				t.Errorf("ObjectKey() = %v, want %v", got, tt.want)
@@ -241,8 +263,8 @@ func TestSignedCertificateS3Object_LoadObject(t *testing.T) {
 				ValidityInterval: 120 * time.Hour,
 			},
 			args: args{
-				s3Svc:       &protocol.MockS3Client{T: t},
-				s3Bucket:    protocol.TestValidBucket,
+				s3Svc:       newTestS3(t),
+				s3Bucket:    testValidBucket,
 				s3ObjectKey: protocol.S3CertStoragePrefix + "host:55e8182ec4413d51676d1ba7480708a48c5b50f4a86b3afb9be6c43c648b373d.json",
 			},
 			wantErr: false,
@@ -250,8 +272,8 @@ func TestSignedCertificateS3Object_LoadObject(t *testing.T) {
 		{
 			name: "Handles errors from S3 gracefully",
 			args: args{
-				s3Svc:       &protocol.MockS3Client{T: t},
-				s3Bucket:    protocol.TestValidBucket,
+				s3Svc:       newTestS3(t),
+				s3Bucket:    testValidBucket,
 				s3ObjectKey: "invalid-key",
 			},
 			wantErr: true,
@@ -259,8 +281,8 @@ func TestSignedCertificateS3Object_LoadObject(t *testing.T) {
 		{
 			name: "Handles corrupt stored certs gracefully",
 			args: args{
-				s3Svc:       &protocol.MockS3Client{T: t},
-				s3Bucket:    protocol.TestValidBucket,
+				s3Svc:       newTestS3(t),
+				s3Bucket:    testValidBucket,
 				s3ObjectKey: "empty-objects",
 			},
 			wantErr: true,
@@ -291,9 +313,10 @@ func TestSignedCertificateS3Object_LoadObject(t *testing.T) {
 
 func TestCertType_OppositeCA(t *testing.T) {
 	tests := []struct {
-		name string
-		ct   protocol.CertType
-		want protocol.CertType
+		name    string
+		ct      protocol.CertType
+		want    protocol.CertType
+		wantErr bool
 	}{
 		{
 			name: "Host yields User",
@@ -306,14 +329,23 @@ func TestCertType_OppositeCA(t *testing.T) {
 			want: protocol.UserCertificate,
 		},
 		{
-			name: "Invalid Type yields empty string",
-			ct:   "admin",
-			want: "",
+			name:    "CA KeyPair has no opposite",
+			ct:      protocol.CaKeyPair,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid Type has no opposite",
+			ct:      "admin",
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.ct.OppositeCA(); got != tt.want {
+			got, err := tt.ct.OppositeCA()
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, protocol.ErrNoOppositeCA)) {
+				t.Fatalf("OppositeCA() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
 				t.Errorf("OppositeCA() = %v, want %v", got, tt.want)
 			}
 		})
@@ -353,3 +385,145 @@ func TestCertType_Expand(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCertType(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    protocol.CertType
+		wantErr bool
+	}{
+		{name: "host", s: "host", want: protocol.HostCertificate},
+		{name: "short user", s: "u", want: protocol.UserCertificate},
+		{name: "cakp", s: "cakp", want: protocol.CaKeyPair},
+		{name: "empty", s: "", wantErr: true},
+		{name: "unknown", s: "hosts", wantErr: true},
+		{name: "case sensitive", s: "Host", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := protocol.ParseCertType(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCertType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, protocol.ErrInvalidCertType) {
+				t.Errorf("ParseCertType() error = %v, want %v", err, protocol.ErrInvalidCertType)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCertType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertType_SSHCertType(t *testing.T) {
+	tests := []struct {
+		name    string
+		ct      protocol.CertType
+		want    uint32
+		wantErr bool
+	}{
+		{name: "host", ct: protocol.HostCertificate, want: ssh.HostCert},
+		{name: "user", ct: protocol.UserCertificate, want: ssh.UserCert},
+		{name: "short host", ct: "h", want: ssh.HostCert},
+		{name: "cakp", ct: protocol.CaKeyPair, wantErr: true},
+		{name: "empty", ct: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.ct.SSHCertType()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SSHCertType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SSHCertType() = %v, want %v", got, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if back, err := protocol.CertTypeFromSSH(got); err != nil || back != tt.ct.Expand() {
+				t.Errorf("CertTypeFromSSH() = %v, %v, want %v", back, err, tt.ct.Expand())
+			}
+		})
+	}
+	if _, err := protocol.CertTypeFromSSH(3); !errors.Is(err, protocol.ErrInvalidCertType) {
+		t.Errorf("CertTypeFromSSH() error = %v, want %v", err, protocol.ErrInvalidCertType)
+	}
+}
+
+func TestCertType_JSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    protocol.CertType
+		wantErr bool
+	}{
+		{name: "host", body: `{"certificate_type":"host"}`, want: protocol.HostCertificate},
+		{name: "short form is expanded", body: `{"certificate_type":"u"}`, want: protocol.UserCertificate},
+		{name: "missing", body: `{}`, wantErr: true},
+		{name: "empty", body: `{"certificate_type":""}`, wantErr: true},
+		{name: "CA KeyPair", body: `{"certificate_type":"cakp"}`, wantErr: true},
+		{name: "unknown", body: `{"certificate_type":"root"}`, wantErr: true},
+		{name: "not a string", body: `{"certificate_type":1}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &protocol.RequestSSHCertLambdaPayload{}
+			err := json.Unmarshal([]byte(tt.body), payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && payload.CertificateType != tt.want {
+				t.Errorf("Unmarshal() CertificateType = %v, want %v", payload.CertificateType, tt.want)
+			}
+			cert := &protocol.SignedCertificateS3Object{}
+			if err := json.Unmarshal([]byte(tt.body), cert); (err != nil) != tt.wantErr {
+				t.Errorf("Unmarshal() SignedCertificateS3Object error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	body, err := json.Marshal(&protocol.RequestSSHCertLambdaResponse{RequestId: "abc"})
+	if err != nil || string(body) != `{"lookup_key":"","request_id":"abc"}` {
+		t.Errorf("Marshal() of a pending response = %s, %v, want no certificate_type", body, err)
+	}
+	if _, err := json.Marshal(&protocol.CAPublicKeyS3Object{}); err != nil {
+		t.Errorf("Marshal() of a zero CAPublicKeyS3Object error = %v", err)
+	}
+	for _, ct := range []protocol.CertType{"", protocol.CaKeyPair} {
+		if err := (&protocol.RequestSSHCertLambdaPayload{CertificateType: ct}).Validate(); !errors.Is(err, protocol.ErrInvalidCertType) {
+			t.Errorf("Validate() of a '%s' payload error = %v, want %v", ct, err, protocol.ErrInvalidCertType)
+		}
+		if err := (&protocol.SignedCertificateS3Object{CertificateType: ct}).Validate(); !errors.Is(err, protocol.ErrInvalidCertType) {
+			t.Errorf("Validate() of a '%s' certificate error = %v, want %v", ct, err, protocol.ErrInvalidCertType)
+		}
+		if _, err := json.Marshal(&protocol.RequestSSHCertLambdaPayload{CertificateType: ct}); !errors.Is(err, protocol.ErrInvalidCertType) {
+			t.Errorf("Marshal() of a '%s' payload error = %v, want %v", ct, err, protocol.ErrInvalidCertType)
+		}
+		if _, err := json.Marshal(&protocol.SignedCertificateS3Object{CertificateType: ct}); !errors.Is(err, protocol.ErrInvalidCertType) {
+			t.Errorf("Marshal() of a '%s' certificate error = %v, want %v", ct, err, protocol.ErrInvalidCertType)
+		}
+	}
+
+	cert := &protocol.SignedCertificateS3Object{}
+	if err := cert.LoadObject(newTestS3Object(t, `{"certificate_type":"hots"}`), testValidBucket, "cert.json"); !errors.Is(err, protocol.ErrInvalidCertType) {
+		t.Errorf("LoadObject() error = %v, want %v", err, protocol.ErrInvalidCertType)
+	}
+	if _, err := json.Marshal(&protocol.SignedCertificateS3Object{CertificateType: "hots"}); !errors.Is(err, protocol.ErrInvalidCertType) {
+		t.Errorf("Marshal() error = %v, want %v", err, protocol.ErrInvalidCertType)
+	}
+	body, err = json.Marshal(&protocol.CAPublicKeyS3Object{CertificateType: "h"})
+	if err != nil || !strings.Contains(string(body), `"certificate_type":"host"`) {
+		t.Errorf("Marshal() = %s, %v, want an expanded certificate_type", body, err)
+	}
+}
+
+// newTestS3Object returns a fake S3 holding body at cert.json
+func newTestS3Object(t *testing.T, body string) s3iface.S3API {
+	t.Helper()
+	s3Svc := fakeaws.NewS3(testValidBucket)
+	if err := s3Svc.Put(testValidBucket, "cert.json", []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	return s3Svc
+}
@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultBackdate is how far before "now" certificates start being valid when the request
+// doesn't set ValidAfter, so hosts with slightly skewed clocks accept freshly minted certificates
+const DefaultBackdate = 5 * time.Minute
+
+// ValidityWindow is the absolute window a certificate is valid in
+type ValidityWindow struct {
+	ValidAfter  time.Time `json:"valid_after"`
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+// Contains reports whether t falls inside the window
+func (w ValidityWindow) Contains(t time.Time) bool {
+	return !t.Before(w.ValidAfter) && t.Before(w.ValidBefore)
+}
+
+// SSHValidity returns the window as the unix timestamps used by ssh.Certificate
+func (w ValidityWindow) SSHValidity() (uint64, uint64) {
+	return uint64(w.ValidAfter.Unix()), uint64(w.ValidBefore.Unix())
+}
+
+// ValidityWindow resolves the requested window into absolute times.
+//
+// Without ValidAfter the window starts at now minus backdate (nil uses DefaultBackdate),
+// an explicit ValidAfter may not be further in the past than the backdate allowance.
+// Without ValidBefore the window ends ValidityInterval after the requested (not backdated) start.
+//
+// Returns an error if the window is empty, already over or can't be determined, or if backdate is negative
+func (p *RequestSSHCertLambdaPayload) ValidityWindow(now time.Time, backdateAllowance *time.Duration) (ValidityWindow, error) {
+	backdate := DefaultBackdate
+	if backdateAllowance != nil {
+		backdate = *backdateAllowance
+	}
+	if backdate < 0 {
+		return ValidityWindow{}, fmt.Errorf("backdate %s must not be negative", backdate)
+	}
+	var (
+		window ValidityWindow
+		start  = now
+	)
+	if p.ValidAfter != nil {
+		if p.ValidAfter.Before(now.Add(-backdate)) {
+			return window, fmt.Errorf("valid_after %s is more than %s in the past", p.ValidAfter.Format(time.RFC3339), backdate)
+		}
+		start = *p.ValidAfter
+		window.ValidAfter = start
+	} else {
+		window.ValidAfter = now.Add(-backdate)
+	}
+	switch {
+	case p.ValidBefore != nil:
+		window.ValidBefore = *p.ValidBefore
+	case p.ValidityInterval > 0:
+		window.ValidBefore = start.Add(p.ValidityInterval)
+	default:
+		return window, fmt.Errorf("one of valid_before or validity_interval is required")
+	}
+	if !window.ValidBefore.After(window.ValidAfter) {
+		return window, fmt.Errorf("valid_before %s must be after valid_after %s",
+			window.ValidBefore.Format(time.RFC3339), window.ValidAfter.Format(time.RFC3339))
+	}
+	if !window.ValidBefore.After(now) {
+		return window, fmt.Errorf("valid_before %s is not in the future", window.ValidBefore.Format(time.RFC3339))
+	}
+	return window, nil
+}
+
+// Window returns the absolute window this Certificate is valid in
+//
+// Objects saved before ValidAfter/ValidBefore were recorded fall back to IssuedOn + ValidityInterval
+func (c *SignedCertificateS3Object) Window() ValidityWindow {
+	if c.ValidAfter.IsZero() && c.ValidBefore.IsZero() {
+		return ValidityWindow{ValidAfter: c.IssuedOn, ValidBefore: c.IssuedOn.Add(c.ValidityInterval)}
+	}
+	return ValidityWindow{ValidAfter: c.ValidAfter, ValidBefore: c.ValidBefore}
+}
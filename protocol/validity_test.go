@@ -0,0 +1,130 @@
+package protocol_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+func TestRequestSSHCertLambdaPayload_ValidityWindow(t *testing.T) {
+	now := time.Date(2022, 5, 22, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	tests := []struct {
+		name     string
+		payload  protocol.RequestSSHCertLambdaPayload
+		backdate *time.Duration
+		want     protocol.ValidityWindow
+		wantErr  bool
+	}{
+		{
+			name:    "interval only is backdated from now",
+			payload: protocol.RequestSSHCertLambdaPayload{ValidityInterval: time.Hour},
+			want:    protocol.ValidityWindow{ValidAfter: now.Add(-protocol.DefaultBackdate), ValidBefore: now.Add(time.Hour)},
+		},
+		{
+			name:     "backdate is configurable",
+			payload:  protocol.RequestSSHCertLambdaPayload{ValidityInterval: time.Hour},
+			backdate: durationPtr(time.Minute),
+			want:     protocol.ValidityWindow{ValidAfter: now.Add(-time.Minute), ValidBefore: now.Add(time.Hour)},
+		},
+		{
+			name:    "explicit start in the future",
+			payload: protocol.RequestSSHCertLambdaPayload{ValidityInterval: time.Hour, ValidAfter: at(24 * time.Hour)},
+			want:    protocol.ValidityWindow{ValidAfter: now.Add(24 * time.Hour), ValidBefore: now.Add(25 * time.Hour)},
+		},
+		{
+			name:     "explicit end wins over the interval",
+			payload:  protocol.RequestSSHCertLambdaPayload{ValidityInterval: time.Hour, ValidBefore: at(2 * time.Hour)},
+			backdate: durationPtr(0),
+			want:     protocol.ValidityWindow{ValidAfter: now, ValidBefore: now.Add(2 * time.Hour)},
+		},
+		{
+			name:    "start too far in the past",
+			payload: protocol.RequestSSHCertLambdaPayload{ValidityInterval: time.Hour, ValidAfter: at(-time.Hour)},
+			wantErr: true,
+		},
+		{
+			name:    "end before start",
+			payload: protocol.RequestSSHCertLambdaPayload{ValidAfter: at(time.Hour), ValidBefore: at(time.Minute)},
+			wantErr: true,
+		},
+		{
+			name:    "end already passed",
+			payload: protocol.RequestSSHCertLambdaPayload{ValidBefore: at(-time.Minute)},
+			wantErr: true,
+		},
+		{
+			name:     "end is now",
+			payload:  protocol.RequestSSHCertLambdaPayload{ValidBefore: at(0)},
+			backdate: durationPtr(time.Minute),
+			wantErr:  true,
+		},
+		{
+			name:     "negative backdate",
+			payload:  protocol.RequestSSHCertLambdaPayload{ValidityInterval: time.Hour},
+			backdate: durationPtr(-time.Minute),
+			wantErr:  true,
+		},
+		{
+			name:    "no end or interval",
+			payload: protocol.RequestSSHCertLambdaPayload{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.payload.ValidityWindow(now, tt.backdate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidityWindow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidityWindow() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+func TestSignedCertificateS3Object_Window(t *testing.T) {
+	issuedOn := time.Date(2022, 5, 22, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		cert protocol.SignedCertificateS3Object
+		want protocol.ValidityWindow
+	}{
+		{
+			name: "recorded window",
+			cert: protocol.SignedCertificateS3Object{
+				IssuedOn:         issuedOn,
+				ValidityInterval: time.Hour,
+				ValidAfter:       issuedOn.Add(-time.Minute),
+				ValidBefore:      issuedOn.Add(time.Hour),
+			},
+			want: protocol.ValidityWindow{ValidAfter: issuedOn.Add(-time.Minute), ValidBefore: issuedOn.Add(time.Hour)},
+		},
+		{
+			name: "legacy objects fall back to IssuedOn and ValidityInterval",
+			cert: protocol.SignedCertificateS3Object{IssuedOn: issuedOn, ValidityInterval: time.Hour},
+			want: protocol.ValidityWindow{ValidAfter: issuedOn, ValidBefore: issuedOn.Add(time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cert.Window()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Window() got = %+v, want %+v", got, tt.want)
+			}
+			if !got.Contains(issuedOn) || got.Contains(got.ValidBefore) {
+				t.Errorf("Contains() is not inclusive of ValidAfter and exclusive of ValidBefore")
+			}
+		})
+	}
+}
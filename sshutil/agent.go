@@ -0,0 +1,141 @@
+package sshutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// CommentPrefix marks the agent entries and certificate files written by this package,
+// the full comment is the prefix followed by the Certificate's LookupKey
+const CommentPrefix = "schism:"
+
+// ErrCertificateExpired is returned when a Certificate has no validity left
+var ErrCertificateExpired = errors.New("certificate has expired")
+
+// DialAgent connects to the ssh-agent listening on $SSH_AUTH_SOCK
+//
+// The returned io.Closer closes the connection
+func DialAgent() (agent.ExtendedAgent, io.Closer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set, is an ssh-agent running?")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to ssh-agent: %w", err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// AddCertificate adds the Certificate to the agent paired with privateKey
+// (any key accepted by agent.AddedKey), then removes any other Schism Certificates
+// of the same type for the same identity
+//
+// The agent drops the Certificate once it expires, its lifetime is the validity left after now.
+// Returns an error if privateKey doesn't match the Certificate or the Certificate has expired
+func AddCertificate(ag agent.Agent, c *protocol.SignedCertificateS3Object, privateKey interface{}, now time.Time) error {
+	cert, err := c.Certificate()
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("unable to use private key: %w", err)
+	}
+	if !keysEqual(signer.PublicKey(), cert.Key) {
+		return fmt.Errorf("private key %s does not match the certificate key %s",
+			ssh.FingerprintSHA256(signer.PublicKey()), ssh.FingerprintSHA256(cert.Key))
+	}
+	lifetime, err := remainingLifetime(cert, now)
+	if err != nil {
+		return err
+	}
+	err = ag.Add(agent.AddedKey{
+		PrivateKey:   privateKey,
+		Certificate:  cert,
+		Comment:      certificateComment(c),
+		LifetimeSecs: lifetime,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add certificate to ssh-agent: %w", err)
+	}
+	return removeStaleCertificates(ag, cert)
+}
+
+// AddCertificateFromFile is AddCertificate with the private key read from an OpenSSH or PEM key file,
+// passphrase is only used if the key is encrypted
+func AddCertificateFromFile(ag agent.Agent, c *protocol.SignedCertificateS3Object, keyPath string, passphrase []byte, now time.Time) error {
+	raw, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("unable to read private key: %w", err)
+	}
+	privateKey, err := ssh.ParseRawPrivateKey(raw)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && len(passphrase) > 0 {
+		privateKey, err = ssh.ParseRawPrivateKeyWithPassphrase(raw, passphrase)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse private key (%s): %w", keyPath, err)
+	}
+	return AddCertificate(ag, c, privateKey, now)
+}
+
+// remainingLifetime returns the agent lifetime for cert, 0 (forever) for certificates that never expire
+func remainingLifetime(cert *ssh.Certificate, now time.Time) (uint32, error) {
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		return 0, nil
+	}
+	validBefore := time.Unix(int64(cert.ValidBefore), 0)
+	remaining := validBefore.Sub(now)
+	if remaining < time.Second {
+		return 0, fmt.Errorf("%w: valid before %s", ErrCertificateExpired, validBefore.UTC().Format(time.RFC3339))
+	}
+	if remaining > time.Duration(^uint32(0))*time.Second {
+		return ^uint32(0), nil
+	}
+	return uint32(remaining / time.Second), nil
+}
+
+// removeStaleCertificates removes the Schism Certificates in the agent
+// with the same type and KeyId as current, other than current itself
+func removeStaleCertificates(ag agent.Agent, current *ssh.Certificate) error {
+	keys, err := ag.List()
+	if err != nil {
+		return fmt.Errorf("unable to list ssh-agent keys: %w", err)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key.Comment, CommentPrefix) {
+			continue
+		}
+		pubKey, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			continue
+		}
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok || cert.CertType != current.CertType || cert.KeyId != current.KeyId || keysEqual(cert, current) {
+			continue
+		}
+		if err = ag.Remove(key); err != nil {
+			return fmt.Errorf("unable to remove stale certificate %s from ssh-agent: %w", key.Comment, err)
+		}
+	}
+	return nil
+}
+
+func certificateComment(c *protocol.SignedCertificateS3Object) string {
+	return CommentPrefix + c.LookupKey().String()
+}
+
+func keysEqual(a, b ssh.PublicKey) bool {
+	return string(a.Marshal()) == string(b.Marshal())
+}
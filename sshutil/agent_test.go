@@ -0,0 +1,132 @@
+package sshutil_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"code.agarg.me/schism/commonLib/protocol"
+	"code.agarg.me/schism/commonLib/sshutil"
+)
+
+func TestAddCertificate(t *testing.T) {
+	now := time.Now()
+	priv, signer := newTestKey(t)
+	otherPriv, otherSigner := newTestKey(t)
+	old := newTestCertificate(t, otherSigner.PublicKey(), protocol.UserCertificate, "alice", now.Add(-2*time.Hour), 4*time.Hour)
+	bob := newTestCertificate(t, otherSigner.PublicKey(), protocol.UserCertificate, "bob", now.Add(-time.Hour), 4*time.Hour)
+	current := newTestCertificate(t, signer.PublicKey(), protocol.UserCertificate, "alice", now.Add(-time.Minute), time.Hour)
+
+	ag := agent.NewKeyring()
+	for _, c := range []*protocol.SignedCertificateS3Object{old, bob} {
+		if err := sshutil.AddCertificate(ag, c, otherPriv, now); err != nil {
+			t.Fatalf("AddCertificate() error = %v", err)
+		}
+	}
+	// Keys added by other tools are left alone
+	if err := ag.Add(agent.AddedKey{PrivateKey: otherPriv, Comment: "alice@laptop"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sshutil.AddCertificate(ag, current, priv, now); err != nil {
+		t.Fatalf("AddCertificate() error = %v", err)
+	}
+
+	keys, _ := ag.List()
+	var got []string
+	for _, key := range keys {
+		got = append(got, key.Comment)
+	}
+	want := []string{"alice@laptop", sshutil.CommentPrefix + bob.LookupKey().String(), sshutil.CommentPrefix + current.LookupKey().String()}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("agent keys = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name    string
+		cert    *protocol.SignedCertificateS3Object
+		key     interface{}
+		wantErr error
+	}{
+		{name: "mismatched key", cert: current, key: otherPriv},
+		{name: "expired", cert: newTestCertificate(t, signer.PublicKey(), protocol.UserCertificate, "alice", now.Add(-2*time.Hour), time.Hour), key: priv, wantErr: sshutil.ErrCertificateExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sshutil.AddCertificate(agent.NewKeyring(), tt.cert, tt.key, now)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("AddCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddCertificateFromFile(t *testing.T) {
+	now := time.Now()
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(helperLoadString(t, "id_ed25519_encrypted.pub")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestCertificate(t, pubKey, protocol.HostCertificate, "test.example.com", now, time.Hour)
+	keyPath := filepath.Join("testdata", "id_ed25519_encrypted")
+
+	ag, closer := startAgent(t)
+	defer closer()
+	if err = sshutil.AddCertificateFromFile(ag, c, keyPath, nil, now); err == nil {
+		t.Errorf("AddCertificateFromFile() error = %v, wantErr %v", err, true)
+	}
+	if err = sshutil.AddCertificateFromFile(ag, c, keyPath, []byte("hunter2"), now); err != nil {
+		t.Fatalf("AddCertificateFromFile() error = %v", err)
+	}
+	signers, err := ag.Signers()
+	if err != nil || len(signers) != 1 {
+		t.Fatalf("Signers() = %v, %v, want the certificate", signers, err)
+	}
+	pub, _ := ssh.ParsePublicKey(signers[0].PublicKey().Marshal())
+	if cert, ok := pub.(*ssh.Certificate); !ok || cert.KeyId != "test.example.com" {
+		t.Errorf("Signers() = %v, want the certificate", signers[0].PublicKey())
+	}
+}
+
+// startAgent serves an in-memory agent on a unix socket and connects to it through DialAgent
+func startAgent(t *testing.T) (agent.ExtendedAgent, func()) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	ag, closer, err := sshutil.DialAgent()
+	if err != nil {
+		t.Fatalf("DialAgent() error = %v", err)
+	}
+	return ag, func() {
+		closer.Close()
+		listener.Close()
+	}
+}
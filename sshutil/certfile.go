@@ -0,0 +1,189 @@
+package sshutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"io/fs"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// CertificateFileMode is the permission certificate files are written with, same as ssh-keygen
+const CertificateFileMode fs.FileMode = 0o644
+
+// Errors returned (wrapped) by WriteCertificateFile
+var (
+	// The Certificate isn't for the key at the given path
+	ErrKeyMismatch = errors.New("certificate does not match the private key")
+	// The existing certificate file is for a different key
+	ErrDifferentKey = errors.New("existing certificate is for a different key")
+	// The existing certificate file was issued after the one being written
+	ErrNewerCertificate = errors.New("existing certificate is newer")
+)
+
+// WriteOptions controls how WriteCertificateFile treats an existing certificate file
+type WriteOptions struct {
+	// Replace the existing file even if it is newer or for a different key
+	Force bool
+}
+
+// CertificatePath returns the path OpenSSH looks for the certificate of a key at,
+//
+//  Example:
+//   ~/.ssh/id_ed25519 => ~/.ssh/id_ed25519-cert.pub
+//   ~/.ssh/id_ed25519.pub => ~/.ssh/id_ed25519-cert.pub
+func CertificatePath(keyPath string) string {
+	return strings.TrimSuffix(keyPath, ".pub") + "-cert.pub"
+}
+
+// WriteCertificateFile writes the Certificate next to the key at keyPath (see CertificatePath)
+// in authorized_keys format, with the LookupKey as the comment
+//
+// The public key is read from {keyPath}.pub, or the private key if that doesn't exist.
+// The file is replaced atomically, an existing certificate that is newer or for another key
+// is kept unless opts.Force is set.
+// Returns the path written
+func WriteCertificateFile(c *protocol.SignedCertificateS3Object, keyPath string, opts *WriteOptions) (string, error) {
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+	keyPath = strings.TrimSuffix(keyPath, ".pub")
+	cert, err := c.Certificate()
+	if err != nil {
+		return "", err
+	}
+	pubKey, err := readPublicKey(keyPath)
+	if err != nil {
+		return "", err
+	}
+	if !keysEqual(pubKey, cert.Key) {
+		return "", fmt.Errorf("%w: %s is %s, certificate is for %s",
+			ErrKeyMismatch, keyPath, ssh.FingerprintSHA256(pubKey), ssh.FingerprintSHA256(cert.Key))
+	}
+
+	certPath := CertificatePath(keyPath)
+	if existing, _, err := readCertificateFile(certPath); err == nil && !opts.Force {
+		if !keysEqual(existing.Key, cert.Key) {
+			return "", fmt.Errorf("%w: %s", ErrDifferentKey, certPath)
+		}
+		if existing.ValidAfter > cert.ValidAfter {
+			return "", fmt.Errorf("%w: %s is valid after %s", ErrNewerCertificate, certPath, certTime(existing.ValidAfter))
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) && !opts.Force {
+		return "", err
+	}
+
+	if err = writeFileAtomic(certPath, certificateFileContent(c, cert), CertificateFileMode); err != nil {
+		return "", err
+	}
+	return certPath, nil
+}
+
+// CleanExpiredCertificates removes the certificate files in dir written by WriteCertificateFile
+// that expired before now, files from other tools are left alone
+//
+// Returns the paths removed
+func CleanExpiredCertificates(dir string, now time.Time) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*-cert.pub"))
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, path := range paths {
+		cert, comment, err := readCertificateFile(path)
+		if err != nil || !strings.HasPrefix(comment, CommentPrefix) {
+			continue
+		}
+		if cert.ValidBefore == ssh.CertTimeInfinity || certTime(cert.ValidBefore).After(now) {
+			continue
+		}
+		if err = os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// certificateFileContent returns cert in authorized_keys format with the LookupKey of c as the comment
+func certificateFileContent(c *protocol.SignedCertificateS3Object, cert *ssh.Certificate) []byte {
+	line := bytes.TrimSpace(ssh.MarshalAuthorizedKey(cert))
+	return append(line, fmt.Sprintf(" %s\n", certificateComment(c))...)
+}
+
+// readPublicKey reads {keyPath}.pub, falling back to deriving it from an unencrypted private key
+func readPublicKey(keyPath string) (ssh.PublicKey, error) {
+	if raw, err := os.ReadFile(keyPath + ".pub"); err == nil {
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse public key (%s.pub): %w", keyPath, err)
+		}
+		return pubKey, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	raw, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key (%s), is %s.pub missing?: %w", keyPath, keyPath, err)
+	}
+	return signer.PublicKey(), nil
+}
+
+// readCertificateFile parses a certificate file and returns the certificate and its comment
+func readCertificateFile(path string) (*ssh.Certificate, string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	pubKey, comment, _, _, err := ssh.ParseAuthorizedKey(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to parse certificate file (%s): %w", path, err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, "", fmt.Errorf("%s is a plain %s key, not a certificate", path, pubKey.Type())
+	}
+	return cert, comment, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and renames it over path,
+// readers never see a partially written file
+func writeFileAtomic(path string, data []byte, mode fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func certTime(t uint64) time.Time {
+	return time.Unix(int64(t), 0).UTC()
+}
@@ -0,0 +1,134 @@
+package sshutil_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"crypto/x509"
+	"encoding/pem"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+	"code.agarg.me/schism/commonLib/sshutil"
+)
+
+func TestCertificatePath(t *testing.T) {
+	for keyPath, want := range map[string]string{
+		"/home/alice/.ssh/id_ed25519":     "/home/alice/.ssh/id_ed25519-cert.pub",
+		"/home/alice/.ssh/id_ed25519.pub": "/home/alice/.ssh/id_ed25519-cert.pub",
+	} {
+		if got := sshutil.CertificatePath(keyPath); got != want {
+			t.Errorf("CertificatePath(%s) = %v, want %v", keyPath, got, want)
+		}
+	}
+}
+
+func TestWriteCertificateFile(t *testing.T) {
+	now := time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC)
+	priv, signer := newTestKey(t)
+	_, other := newTestKey(t)
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_ed25519")
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	older := newTestCertificate(t, signer.PublicKey(), protocol.UserCertificate, "alice", now, time.Hour)
+	newer := newTestCertificate(t, signer.PublicKey(), protocol.UserCertificate, "alice", now.Add(time.Hour), time.Hour)
+	otherKey := newTestCertificate(t, other.PublicKey(), protocol.UserCertificate, "alice", now.Add(2*time.Hour), time.Hour)
+
+	// No id_ed25519.pub yet, the public key comes from the private key
+	certPath, err := sshutil.WriteCertificateFile(newer, keyPath, nil)
+	if err != nil {
+		t.Fatalf("WriteCertificateFile() error = %v", err)
+	}
+	if err = os.WriteFile(keyPath+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(certPath)
+	if err != nil || info.Mode().Perm() != sshutil.CertificateFileMode {
+		t.Errorf("certificate file mode = %v, %v, want %v", info.Mode().Perm(), err, sshutil.CertificateFileMode)
+	}
+	raw, _ := os.ReadFile(certPath)
+	if want := " " + sshutil.CommentPrefix + newer.LookupKey().String() + "\n"; !strings.HasSuffix(string(raw), want) {
+		t.Errorf("certificate file = %q, want comment %q", raw, want)
+	}
+
+	tests := []struct {
+		name    string
+		cert    *protocol.SignedCertificateS3Object
+		opts    *sshutil.WriteOptions
+		wantErr error
+	}{
+		{name: "same certificate again", cert: newer},
+		{name: "older certificate", cert: older, wantErr: sshutil.ErrNewerCertificate},
+		{name: "certificate for another key", cert: otherKey, wantErr: sshutil.ErrKeyMismatch},
+		{name: "forced older certificate", cert: older, opts: &sshutil.WriteOptions{Force: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sshutil.WriteCertificateFile(tt.cert, keyPath+".pub", tt.opts)
+			if (err != nil) != (tt.wantErr != nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("WriteCertificateFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("existing certificate for another key", func(t *testing.T) {
+		if err := os.WriteFile(certPath, otherKey.RawSignedCertificate, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sshutil.WriteCertificateFile(newer, keyPath, nil); !errors.Is(err, sshutil.ErrDifferentKey) {
+			t.Errorf("WriteCertificateFile() error = %v, wantErr %v", err, sshutil.ErrDifferentKey)
+		}
+	})
+}
+
+func TestCleanExpiredCertificates(t *testing.T) {
+	now := time.Date(2022, 5, 22, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	var want []string
+	for name, validity := range map[string]time.Duration{"expired": time.Hour, "valid": 24 * time.Hour} {
+		_, signer := newTestKey(t)
+		keyPath := filepath.Join(dir, name)
+		if err := os.WriteFile(keyPath+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		c := newTestCertificate(t, signer.PublicKey(), protocol.UserCertificate, "alice", now.Add(-2*time.Hour), validity)
+		certPath, err := sshutil.WriteCertificateFile(c, keyPath, nil)
+		if err != nil {
+			t.Fatalf("WriteCertificateFile() error = %v", err)
+		}
+		if name == "expired" {
+			want = append(want, certPath)
+		}
+	}
+	// Expired certificates written by other tools are left alone
+	_, signer := newTestKey(t)
+	foreign := newTestCertificate(t, signer.PublicKey(), protocol.UserCertificate, "alice", now.Add(-2*time.Hour), time.Hour)
+	if err := os.WriteFile(filepath.Join(dir, "foreign-cert.pub"), foreign.RawSignedCertificate, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sshutil.CleanExpiredCertificates(dir, now)
+	if err != nil {
+		t.Fatalf("CleanExpiredCertificates() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CleanExpiredCertificates() got = %v, want %v", got, want)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "foreign-cert.pub")); err != nil {
+		t.Errorf("foreign certificate was removed: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package sshutil
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"io/fs"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// DefaultSSHDir is where sshd reads its configuration from on most systems
+const DefaultSSHDir = "/etc/ssh"
+
+// Names of the files rendered by SSHDConfig and SSHConfig, relative to their Dir
+const (
+	SSHDConfigSnippet       = "sshd_config.d/50-schism.conf"
+	TrustedUserCAKeysFile   = "schism_user_ca.pub"
+	AuthorizedPrincipalsDir = "schism_principals"
+	SSHConfigSnippet        = "ssh_config.d/50-schism.conf"
+	KnownHostsFile          = "schism_known_hosts"
+)
+
+// generatedHeader starts every rendered file
+const generatedHeader = "# Generated by Schism, changes will be overwritten\n"
+
+// defaultUserKnownHostsFile is kept in UserKnownHostsFile so hosts without certificates still verify
+const defaultUserKnownHostsFile = "~/.ssh/known_hosts"
+
+// ConfigFile is a rendered file ready to be installed
+type ConfigFile struct {
+	// Absolute path (when Dir is absolute) the file should be installed at
+	Path string
+	// Permissions to install the file with
+	Mode fs.FileMode
+	// The full content of the file
+	Content []byte
+}
+
+// SSHDConfig describes the Schism parts of a host's sshd configuration
+type SSHDConfig struct {
+	// Directory sshd reads its configuration from, DefaultSSHDir if empty
+	Dir string
+	// Host Certificates, each is installed next to the default host key of its key type
+	// (e.g. ssh_host_ed25519_key-cert.pub)
+	HostCertificates []*protocol.SignedCertificateS3Object
+	// User CAs whose Certificates are trusted for logins
+	UserCAs []*protocol.CAPublicKeyS3Object
+	// Principals accepted for each local user, leave empty to let sshd
+	// accept certificates listing the user name as a principal
+	AuthorizedPrincipals map[string][]string
+}
+
+// Render returns the sshd_config.d snippet and every file it references
+//
+// Returns an error if a Certificate or CA is of the wrong CertType,
+// a host key type has no default key file or two host Certificates share a key file
+func (c *SSHDConfig) Render() ([]ConfigFile, error) {
+	dir := c.Dir
+	if dir == "" {
+		dir = DefaultSSHDir
+	}
+	var (
+		files []ConfigFile
+		conf  bytes.Buffer
+	)
+	conf.WriteString(generatedHeader)
+	certPaths := map[string]bool{}
+	for _, hostCert := range c.HostCertificates {
+		if hostCert.CertificateType != protocol.HostCertificate {
+			return nil, fmt.Errorf("%s is a %s certificate, not a host certificate", hostCert.LookupKey(), hostCert.CertificateType)
+		}
+		cert, err := hostCert.Certificate()
+		if err != nil {
+			return nil, err
+		}
+		keyName, err := hostKeyName(cert.Key.Type())
+		if err != nil {
+			return nil, err
+		}
+		certPath := CertificatePath(filepath.Join(dir, keyName))
+		if certPaths[certPath] {
+			// e.g. ECDSA keys of every curve share ssh_host_ecdsa_key
+			return nil, fmt.Errorf("%s would overwrite another host certificate at %s", hostCert.LookupKey(), certPath)
+		}
+		certPaths[certPath] = true
+		fmt.Fprintf(&conf, "HostCertificate %s\n", certPath)
+		files = append(files, ConfigFile{Path: certPath, Mode: CertificateFileMode, Content: certificateFileContent(hostCert, cert)})
+	}
+	if len(c.UserCAs) > 0 {
+		content, err := caKeyLines(c.UserCAs, protocol.UserCertificate, func(*protocol.CAPublicKeyS3Object) string { return "" })
+		if err != nil {
+			return nil, err
+		}
+		caPath := filepath.Join(dir, TrustedUserCAKeysFile)
+		fmt.Fprintf(&conf, "TrustedUserCAKeys %s\n", caPath)
+		files = append(files, ConfigFile{Path: caPath, Mode: 0o644, Content: content})
+	}
+	if len(c.AuthorizedPrincipals) > 0 {
+		principalsDir := filepath.Join(dir, AuthorizedPrincipalsDir)
+		fmt.Fprintf(&conf, "AuthorizedPrincipalsFile %s\n", filepath.Join(principalsDir, "%u"))
+		for _, user := range sortedKeys(c.AuthorizedPrincipals) {
+			if user == "" || strings.ContainsAny(user, `/\`) || user == "." || user == ".." {
+				return nil, fmt.Errorf("invalid user name '%s'", user)
+			}
+			content := generatedHeader + strings.Join(c.AuthorizedPrincipals[user], "\n") + "\n"
+			files = append(files, ConfigFile{Path: filepath.Join(principalsDir, user), Mode: 0o644, Content: []byte(content)})
+		}
+	}
+	snippet := ConfigFile{Path: filepath.Join(dir, SSHDConfigSnippet), Mode: 0o644, Content: conf.Bytes()}
+	return append([]ConfigFile{snippet}, files...), nil
+}
+
+// UserCertificate pairs a user Certificate with the private key it certifies
+type UserCertificate struct {
+	// Path of the private key, the Certificate is installed at CertificatePath(KeyPath)
+	KeyPath     string
+	Certificate *protocol.SignedCertificateS3Object
+}
+
+// SSHConfig describes the Schism parts of a user's ssh client configuration
+type SSHConfig struct {
+	// Directory the client reads its configuration from, usually ~/.ssh or /etc/ssh.
+	// A leading "~" is expanded to the current user's home directory, the result must be absolute
+	Dir string
+	// Host patterns the snippet applies to, "*" if empty
+	Hosts []string
+	// User Certificates to offer when connecting
+	UserCertificates []UserCertificate
+	// Host CAs to trust, restricted to their HostCertAuthDomain when set
+	HostCAs []*protocol.CAPublicKeyS3Object
+}
+
+// Render returns the ssh_config.d snippet and every file it references
+//
+// Returns an error if Dir isn't absolute or a Certificate or CA is of the wrong CertType
+func (c *SSHConfig) Render() ([]ConfigFile, error) {
+	dir, err := expandDir(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		files []ConfigFile
+		conf  bytes.Buffer
+	)
+	hosts := c.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{"*"}
+	}
+	conf.WriteString(generatedHeader)
+	fmt.Fprintf(&conf, "Host %s\n", strings.Join(hosts, " "))
+	for _, userCert := range c.UserCertificates {
+		if userCert.Certificate.CertificateType != protocol.UserCertificate {
+			return nil, fmt.Errorf("%s is a %s certificate, not a user certificate",
+				userCert.Certificate.LookupKey(), userCert.Certificate.CertificateType)
+		}
+		cert, err := userCert.Certificate.Certificate()
+		if err != nil {
+			return nil, err
+		}
+		certPath := CertificatePath(userCert.KeyPath)
+		fmt.Fprintf(&conf, "    IdentityFile %s\n", strings.TrimSuffix(userCert.KeyPath, ".pub"))
+		fmt.Fprintf(&conf, "    CertificateFile %s\n", certPath)
+		files = append(files, ConfigFile{Path: certPath, Mode: CertificateFileMode, Content: certificateFileContent(userCert.Certificate, cert)})
+	}
+	if len(c.HostCAs) > 0 {
+		content, err := caKeyLines(c.HostCAs, protocol.HostCertificate, func(ca *protocol.CAPublicKeyS3Object) string {
+			return "@cert-authority " + hostPatterns(ca.HostCertAuthDomain) + " "
+		})
+		if err != nil {
+			return nil, err
+		}
+		knownHostsPath := filepath.Join(dir, KnownHostsFile)
+		fmt.Fprintf(&conf, "    UserKnownHostsFile %s %s\n", defaultUserKnownHostsFile, knownHostsPath)
+		files = append(files, ConfigFile{Path: knownHostsPath, Mode: 0o644, Content: content})
+	}
+	snippet := ConfigFile{Path: filepath.Join(dir, SSHConfigSnippet), Mode: 0o644, Content: conf.Bytes()}
+	return append([]ConfigFile{snippet}, files...), nil
+}
+
+// expandDir expands a leading "~" in dir to the current user's home directory
+//
+// Returns an error if the result isn't absolute, rendered paths must not depend on the working directory
+func expandDir(dir string) (string, error) {
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to expand '%s': %w", dir, err)
+		}
+		dir = filepath.Join(home, dir[1:])
+	}
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("config directory '%s' is not an absolute path", dir)
+	}
+	return dir, nil
+}
+
+// WriteConfigFiles installs every file atomically, creating parent directories as needed
+func WriteConfigFiles(files []ConfigFile) error {
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0o755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(file.Path, file.Content, file.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostKeyNames are the default sshd host key files for each key type
+var hostKeyNames = map[string]string{
+	ssh.KeyAlgoED25519:  "ssh_host_ed25519_key",
+	ssh.KeyAlgoECDSA256: "ssh_host_ecdsa_key",
+	ssh.KeyAlgoECDSA384: "ssh_host_ecdsa_key",
+	ssh.KeyAlgoECDSA521: "ssh_host_ecdsa_key",
+	ssh.KeyAlgoRSA:      "ssh_host_rsa_key",
+}
+
+func hostKeyName(keyType string) (string, error) {
+	name, ok := hostKeyNames[keyType]
+	if !ok {
+		return "", fmt.Errorf("no default host key file for %s keys", keyType)
+	}
+	return name, nil
+}
+
+// caKeyLines renders one line per CA, prefixed by prefix(ca)
+func caKeyLines(cas []*protocol.CAPublicKeyS3Object, certType protocol.CertType, prefix func(*protocol.CAPublicKeyS3Object) string) ([]byte, error) {
+	var content bytes.Buffer
+	content.WriteString(generatedHeader)
+	for _, ca := range cas {
+		if ca.CertificateType != certType {
+			return nil, fmt.Errorf("CA %s is a %s CA, not a %s CA", ca.KeyFingerprint, ca.CertificateType, certType)
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey(ca.AuthorizedKey); err != nil {
+			return nil, fmt.Errorf("unable to parse %s CA key: %w", certType, err)
+		}
+		content.WriteString(prefix(ca))
+		content.Write(bytes.TrimSpace(ca.AuthorizedKey))
+		content.WriteString("\n")
+	}
+	return content.Bytes(), nil
+}
+
+// hostPatterns turns a (comma separated) HostCertAuthDomain into known_hosts patterns,
+// each domain matches itself and its subdomains
+func hostPatterns(authDomain string) string {
+	var patterns []string
+	for _, domain := range strings.Split(authDomain, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			patterns = append(patterns, domain, "*."+domain)
+		}
+	}
+	if len(patterns) == 0 {
+		return "*"
+	}
+	return strings.Join(patterns, ",")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
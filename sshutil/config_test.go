@@ -0,0 +1,171 @@
+package sshutil_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"crypto/elliptic"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+	"code.agarg.me/schism/commonLib/sshutil"
+)
+
+func TestSSHDConfig_Render(t *testing.T) {
+	now := time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC)
+	_, hostKey := newTestKey(t)
+	_, userCA := newTestKey(t)
+	hostCert := newTestCertificate(t, hostKey.PublicKey(), protocol.HostCertificate, "test.example.com", now, time.Hour)
+	userCAObj := &protocol.CAPublicKeyS3Object{
+		CertificateType: protocol.UserCertificate,
+		AuthorizedKey:   ssh.MarshalAuthorizedKey(userCA.PublicKey()),
+	}
+	dir := t.TempDir()
+	config := &sshutil.SSHDConfig{
+		Dir:                  dir,
+		HostCertificates:     []*protocol.SignedCertificateS3Object{hostCert},
+		UserCAs:              []*protocol.CAPublicKeyS3Object{userCAObj},
+		AuthorizedPrincipals: map[string][]string{"root": {"admin"}, "deploy": {"deploy", "ci"}},
+	}
+	files, err := config.Render()
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	got := renderedFiles(t, files)
+	wantSnippet := strings.Join([]string{
+		"# Generated by Schism, changes will be overwritten",
+		"HostCertificate " + filepath.Join(dir, "ssh_host_ed25519_key-cert.pub"),
+		"TrustedUserCAKeys " + filepath.Join(dir, sshutil.TrustedUserCAKeysFile),
+		"AuthorizedPrincipalsFile " + filepath.Join(dir, sshutil.AuthorizedPrincipalsDir, "%u"),
+		"",
+	}, "\n")
+	if snippet := got[filepath.Join(dir, sshutil.SSHDConfigSnippet)]; snippet != wantSnippet {
+		t.Errorf("Render() snippet = %q, want %q", snippet, wantSnippet)
+	}
+	if principals := got[filepath.Join(dir, sshutil.AuthorizedPrincipalsDir, "deploy")]; !strings.HasSuffix(principals, "\ndeploy\nci\n") {
+		t.Errorf("Render() principals = %q", principals)
+	}
+	if ca := got[filepath.Join(dir, sshutil.TrustedUserCAKeysFile)]; !strings.HasSuffix(ca, string(userCAObj.AuthorizedKey)) {
+		t.Errorf("Render() user CA = %q", ca)
+	}
+	if len(files) != 5 {
+		t.Errorf("Render() returned %d files, want 5", len(files))
+	}
+
+	if err = sshutil.WriteConfigFiles(files); err != nil {
+		t.Fatalf("WriteConfigFiles() error = %v", err)
+	}
+	for _, file := range files {
+		if raw, err := os.ReadFile(file.Path); err != nil || string(raw) != string(file.Content) {
+			t.Errorf("WriteConfigFiles() %s = %q, %v, want %q", file.Path, raw, err, file.Content)
+		}
+	}
+
+	badConfigs := map[string]*sshutil.SSHDConfig{
+		"user certificate as host certificate": {HostCertificates: []*protocol.SignedCertificateS3Object{
+			newTestCertificate(t, hostKey.PublicKey(), protocol.UserCertificate, "alice", now, time.Hour),
+		}},
+		"host certificates sharing a key file": {HostCertificates: []*protocol.SignedCertificateS3Object{
+			newTestCertificate(t, newTestECDSAKey(t, elliptic.P256()), protocol.HostCertificate, "test.example.com", now, time.Hour),
+			newTestCertificate(t, newTestECDSAKey(t, elliptic.P384()), protocol.HostCertificate, "test.example.com", now, time.Hour),
+		}},
+		"host CA as user CA":     {UserCAs: []*protocol.CAPublicKeyS3Object{{CertificateType: protocol.HostCertificate}}},
+		"user name with a slash": {AuthorizedPrincipals: map[string][]string{"../root": {"admin"}}},
+	}
+	for name, config := range badConfigs {
+		t.Run(name, func(t *testing.T) {
+			if _, err := config.Render(); err == nil {
+				t.Errorf("Render() error = %v, wantErr %v", err, true)
+			}
+		})
+	}
+}
+
+func TestSSHConfig_Render(t *testing.T) {
+	now := time.Date(2022, 5, 22, 0, 0, 0, 0, time.UTC)
+	_, userKey := newTestKey(t)
+	_, hostCA := newTestKey(t)
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id_ed25519")
+	userCert := newTestCertificate(t, userKey.PublicKey(), protocol.UserCertificate, "alice", now, time.Hour)
+	hostCAObj := &protocol.CAPublicKeyS3Object{
+		CertificateType:    protocol.HostCertificate,
+		AuthorizedKey:      ssh.MarshalAuthorizedKey(hostCA.PublicKey()),
+		HostCertAuthDomain: "example.com,example.org",
+	}
+	config := &sshutil.SSHConfig{
+		Dir:              dir,
+		Hosts:            []string{"*.example.com", "*.example.org"},
+		UserCertificates: []sshutil.UserCertificate{{KeyPath: keyPath, Certificate: userCert}},
+		HostCAs:          []*protocol.CAPublicKeyS3Object{hostCAObj},
+	}
+	files, err := config.Render()
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	got := renderedFiles(t, files)
+	wantSnippet := strings.Join([]string{
+		"# Generated by Schism, changes will be overwritten",
+		"Host *.example.com *.example.org",
+		"    IdentityFile " + keyPath,
+		"    CertificateFile " + keyPath + "-cert.pub",
+		"    UserKnownHostsFile ~/.ssh/known_hosts " + filepath.Join(dir, sshutil.KnownHostsFile),
+		"",
+	}, "\n")
+	if snippet := got[filepath.Join(dir, sshutil.SSHConfigSnippet)]; snippet != wantSnippet {
+		t.Errorf("Render() snippet = %q, want %q", snippet, wantSnippet)
+	}
+	wantKnownHosts := "@cert-authority example.com,*.example.com,example.org,*.example.org " + string(hostCAObj.AuthorizedKey)
+	if knownHosts := got[filepath.Join(dir, sshutil.KnownHostsFile)]; !strings.HasSuffix(knownHosts, wantKnownHosts) {
+		t.Errorf("Render() known hosts = %q, want suffix %q", knownHosts, wantKnownHosts)
+	}
+	certFile := got[keyPath+"-cert.pub"]
+	if pubKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(certFile)); err != nil || comment != sshutil.CommentPrefix+userCert.LookupKey().String() {
+		t.Errorf("Render() certificate file = %v, %q, %v", pubKey, comment, err)
+	}
+}
+
+func TestSSHConfig_Render_Dir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	tests := []struct {
+		name    string
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{name: "absolute", dir: "/etc/ssh", want: "/etc/ssh"},
+		{name: "home", dir: "~", want: home},
+		{name: "below home", dir: "~/.ssh", want: filepath.Join(home, ".ssh")},
+		{name: "empty", dir: "", wantErr: true},
+		{name: "relative", dir: ".ssh", wantErr: true},
+		{name: "other user", dir: "~bob/.ssh", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := (&sshutil.SSHConfig{Dir: tt.dir}).Render()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if want := filepath.Join(tt.want, sshutil.SSHConfigSnippet); !tt.wantErr && files[0].Path != want {
+				t.Errorf("Render() snippet path = %v, want %v", files[0].Path, want)
+			}
+		})
+	}
+}
+
+func renderedFiles(t *testing.T, files []sshutil.ConfigFile) map[string]string {
+	t.Helper()
+	got := map[string]string{}
+	for _, file := range files {
+		if _, dup := got[file.Path]; dup {
+			t.Errorf("Render() returned %s twice", file.Path)
+		}
+		got[file.Path] = string(file.Content)
+	}
+	return got
+}
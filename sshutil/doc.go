@@ -0,0 +1,7 @@
+// Package sshutil puts Schism certificates to work with OpenSSH
+// on the machines that requested them.
+//
+// It covers loading certificates into a running ssh-agent,
+// writing certificate files next to their private keys
+// and rendering ssh_config / sshd_config snippets.
+package sshutil
@@ -0,0 +1,87 @@
+package sshutil_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+
+	"golang.org/x/crypto/ssh"
+
+	"code.agarg.me/schism/commonLib/protocol"
+)
+
+// newTestKey returns a freshly generated ed25519 private key and its ssh.Signer
+func newTestKey(t *testing.T) (ed25519.PrivateKey, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, signer
+}
+
+// newTestCertificate signs pubKey with a throwaway CA and returns the stored object
+func newTestCertificate(t *testing.T, pubKey ssh.PublicKey, certType protocol.CertType, ident string, issuedOn time.Time, validity time.Duration) *protocol.SignedCertificateS3Object {
+	t.Helper()
+	_, ca := newTestKey(t)
+	sshCertType, err := certType.SSHCertType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             pubKey,
+		CertType:        sshCertType,
+		KeyId:           ident,
+		ValidPrincipals: []string{ident},
+		ValidAfter:      uint64(issuedOn.Unix()),
+		ValidBefore:     uint64(issuedOn.Add(validity).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return &protocol.SignedCertificateS3Object{
+		CertificateType:      certType,
+		IssuedOn:             issuedOn,
+		Identity:             ident,
+		Principals:           []string{ident},
+		ValidityInterval:     validity,
+		ValidAfter:           issuedOn,
+		ValidBefore:          issuedOn.Add(validity),
+		RawSignedCertificate: ssh.MarshalAuthorizedKey(cert),
+		PublicKeyFingerprint: ssh.FingerprintSHA256(pubKey),
+		LookupKeyVersion:     protocol.LookupKeyV2,
+	}
+}
+
+func helperLoadString(t *testing.T, name string) string {
+	t.Helper()
+	bytes, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes)
+}
+
+// newTestECDSAKey returns the public half of a freshly generated ECDSA key on curve
+func newTestECDSAKey(t *testing.T, curve elliptic.Curve) ssh.PublicKey {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKey
+}